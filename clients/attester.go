@@ -7,43 +7,165 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/forta-network/forta-json-rpc-proxy/interfaces"
+	"github.com/forta-network/forta-json-rpc-proxy/metrics"
 	"github.com/forta-network/forta-json-rpc-proxy/utils"
+	"github.com/sirupsen/logrus"
 )
 
+const maxAttesterBackoff = 5 * time.Second
+
+// attesterHTTPClient has no timeout of its own as the attest requests are limited by
+// the attempt timeout and the budget of the attestation.
+var attesterHTTPClient = &http.Client{Transport: utils.DefaultHTTPTransport}
+
+// retriableStatusCodes are the attester response codes which are worth retrying.
+var retriableStatusCodes = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
 type attesterClient struct {
-	attesterUrl string
-	authToken   string
+	attesterUrl    string
+	authToken      string
+	attemptTimeout time.Duration
+	retries        int
+	backoff        time.Duration
+	breaker        *CircuitBreaker
 }
 
-// NewAttesterClient creates a new attester client.
-func NewAttesterClient(attesterUrl, authToken string) *attesterClient {
-	return &attesterClient{attesterUrl: attesterUrl, authToken: authToken}
+// NewAttesterClient creates a new attester client. Every attempt is limited by the
+// attempt timeout and the failed attempts are retried with jittered exponential backoff.
+// The attester is treated as unavailable while the breaker is open.
+func NewAttesterClient(
+	attesterUrl, authToken string, attemptTimeout time.Duration, retries int, backoff time.Duration, breaker *CircuitBreaker,
+) *attesterClient {
+	return &attesterClient{
+		attesterUrl:    attesterUrl,
+		authToken:      authToken,
+		attemptTimeout: attemptTimeout,
+		retries:        retries,
+		backoff:        backoff,
+		breaker:        breaker,
+	}
+}
+
+var _ interfaces.HealthChecker = &attesterClient{}
+
+// CheckHealth implements interfaces.HealthChecker. The attester is considered reachable
+// as long as it does not respond with a server error.
+func (ac *attesterClient) CheckHealth(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", ac.attesterUrl, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create new request: %v", err)
+	}
+	resp, err := attesterHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("attester is not reachable: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("attester responded with code %d", resp.StatusCode)
+	}
+	return nil
 }
 
 type errorResponse struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code        int      `json:"code"`
+	Message     string   `json:"message"`
+	ReferenceID string   `json:"referenceId"`
+	RiskLabels  []string `json:"riskLabels"`
+}
+
+// retriableError is an attempt error which is worth retrying.
+type retriableError struct {
+	err error
+}
+
+func (re *retriableError) Error() string {
+	return re.err.Error()
 }
 
 // AttestWithTx retrieves back an attestation.
 func (ac *attesterClient) AttestWithTx(ctx context.Context, attReq *interfaces.AttestRequest) (tx hexutil.Bytes, err error) {
+	outcome := metrics.AttesterOutcomeError
+	defer func() {
+		metrics.AttesterOutcomes.WithLabelValues(outcome).Inc()
+	}()
+
+	if !ac.breaker.Allow() {
+		outcome = metrics.AttesterOutcomeUnavailable
+		return nil, interfaces.ErrAttesterUnavailable
+	}
+
 	var b bytes.Buffer
 	if err := json.NewEncoder(&b).Encode(attReq); err != nil {
 		return nil, fmt.Errorf("failed to encode attest request: %v", err)
 	}
-	req, err := http.NewRequestWithContext(ctx, "POST", ac.attesterUrl+"/attest-tx", &b)
+
+	for attempt := 0; ; attempt++ {
+		tx, outcome, err = ac.attest(ctx, b.Bytes())
+		var retriable *retriableError
+		if !errors.As(err, &retriable) {
+			// The attester is up, even if it says no.
+			ac.breaker.Success()
+			return tx, err
+		}
+		// Running out of time is a failure, unlike the request being canceled.
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return nil, err
+		}
+		if ctx.Err() != nil || attempt >= ac.retries {
+			ac.breaker.Failure()
+			outcome = metrics.AttesterOutcomeUnavailable
+			return nil, fmt.Errorf("%w: %v", interfaces.ErrAttesterUnavailable, err)
+		}
+		backoff := ac.backoffOf(attempt)
+		logrus.WithError(err).WithField("backoff", backoff).Debug("attest request failed - will retry")
+		if sleepContext(ctx, backoff) != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				ac.breaker.Failure()
+				outcome = metrics.AttesterOutcomeUnavailable
+				return nil, fmt.Errorf("%w: %v", interfaces.ErrAttesterUnavailable, err)
+			}
+			return nil, err
+		}
+	}
+}
+
+// backoffOf returns the exponential backoff of an attempt with full jitter.
+func (ac *attesterClient) backoffOf(attempt int) time.Duration {
+	backoff := ac.backoff << attempt
+	if backoff <= 0 || backoff > maxAttesterBackoff {
+		backoff = maxAttesterBackoff
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// attest makes a single attest request.
+func (ac *attesterClient) attest(ctx context.Context, body []byte) (hexutil.Bytes, string, error) {
+	if ac.attemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ac.attemptTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", ac.attesterUrl+"/attest-tx", bytes.NewReader(body))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create new request: %v", err)
+		return nil, metrics.AttesterOutcomeError, fmt.Errorf("failed to create new request: %v", err)
 	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", ac.authToken))
 
-	resp, err := utils.DefaultHTTPClient.Do(req)
+	resp, err := attesterHTTPClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("attest request failed: %v", err)
+		return nil, metrics.AttesterOutcomeError, &retriableError{fmt.Errorf("attest request failed: %v", err)}
 	}
 	defer resp.Body.Close()
 
@@ -53,25 +175,35 @@ func (ac *attesterClient) AttestWithTx(ctx context.Context, attReq *interfaces.A
 			Tx hexutil.Bytes `json:"tx"`
 		}
 		if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
-			return nil, fmt.Errorf("failed to decode 200 body from attest response: %v", err)
+			return nil, metrics.AttesterOutcomeError, fmt.Errorf("failed to decode 200 body from attest response: %v", err)
 		}
-		return respBody.Tx, nil
+		return respBody.Tx, metrics.AttesterOutcomeAttested, nil
 
 	case 406:
-		return nil, interfaces.ErrAttestationNotRequired
+		return nil, metrics.AttesterOutcomeNotRequired, interfaces.ErrAttestationNotRequired
 
 	case 409:
 		var respBody errorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&respBody); err != nil {
-			return nil, fmt.Errorf("failed to decode 409 body from attest response: %v", err)
+			return nil, metrics.AttesterOutcomeError, fmt.Errorf("failed to decode 409 body from attest response: %v", err)
+		}
+		return nil, metrics.AttesterOutcomeRejected, &interfaces.AttestationRejectedError{
+			Code:        respBody.Code,
+			Message:     respBody.Message,
+			ReferenceID: respBody.ReferenceID,
+			RiskLabels:  respBody.RiskLabels,
 		}
-		return nil, errors.New(respBody.Message)
 
 	default:
 		b, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read %d body from attest response: %v", resp.StatusCode, err)
+			err = fmt.Errorf("failed to read %d body from attest response: %v", resp.StatusCode, err)
+		} else {
+			err = fmt.Errorf("attest request failed with code %d: %s", resp.StatusCode, string(b))
+		}
+		if retriableStatusCodes[resp.StatusCode] {
+			err = &retriableError{err}
 		}
-		return nil, fmt.Errorf("attest request failed with code %d: %s", resp.StatusCode, string(b))
+		return nil, metrics.AttesterOutcomeError, err
 	}
 }
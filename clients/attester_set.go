@@ -0,0 +1,133 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/forta-network/forta-json-rpc-proxy/interfaces"
+	"github.com/sirupsen/logrus"
+)
+
+// AttesterEndpoint is the config of an attester API.
+type AttesterEndpoint struct {
+	URL      string   `json:"url"`
+	Token    string   `json:"token"`
+	Priority int      `json:"priority"`
+	ChainIDs []uint64 `json:"chainIds,omitempty"`
+}
+
+// LoadAttesterEndpoints reads the attester endpoints from a JSON file.
+func LoadAttesterEndpoints(path string) ([]*AttesterEndpoint, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attesters file: %v", err)
+	}
+	var endpoints []*AttesterEndpoint
+	if err := json.Unmarshal(b, &endpoints); err != nil {
+		return nil, fmt.Errorf("failed to decode attesters file: %v", err)
+	}
+	for i, endpoint := range endpoints {
+		if len(endpoint.URL) == 0 {
+			return nil, fmt.Errorf("attester %d has no url", i)
+		}
+	}
+	return endpoints, nil
+}
+
+// PrioritizedAttester is an attester in an attester set. The attesters with lower
+// priority values are tried first. The attester serves all chains if the chain IDs
+// are not specified.
+type PrioritizedAttester struct {
+	Name     string
+	Attester interfaces.Attester
+	Priority int
+	ChainIDs []uint64
+}
+
+func (pa *PrioritizedAttester) serves(chainID uint64) bool {
+	return len(pa.ChainIDs) == 0 || slices.Contains(pa.ChainIDs, chainID)
+}
+
+// AttesterSet is an attester which fails over through a set of attesters, in the
+// order of priority, until one of them gives a definitive outcome or the budget is
+// spent.
+type AttesterSet struct {
+	attesters []*PrioritizedAttester
+	budget    time.Duration
+}
+
+var (
+	_ interfaces.Attester      = &AttesterSet{}
+	_ interfaces.HealthChecker = &AttesterSet{}
+)
+
+// NewAttesterSet creates a new attester set. The budget limits the total time of an
+// attestation, including the retries and the failovers.
+func NewAttesterSet(attesters []*PrioritizedAttester, budget time.Duration) *AttesterSet {
+	attesters = append([]*PrioritizedAttester{}, attesters...)
+	sort.SliceStable(attesters, func(i, j int) bool {
+		return attesters[i].Priority < attesters[j].Priority
+	})
+	return &AttesterSet{attesters: attesters, budget: budget}
+}
+
+// AttestWithTx implements interfaces.Attester.
+func (as *AttesterSet) AttestWithTx(ctx context.Context, req *interfaces.AttestRequest) (hexutil.Bytes, error) {
+	if as.budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, as.budget)
+		defer cancel()
+	}
+	err := fmt.Errorf("no attester for chain %d", req.ChainID)
+	unavailable := true
+	for _, attester := range as.attesters {
+		if !attester.serves(req.ChainID) {
+			continue
+		}
+		var tx hexutil.Bytes
+		tx, err = attester.Attester.AttestWithTx(ctx, req)
+		if isDefinitiveAttestation(err) || ctx.Err() != nil {
+			return tx, err
+		}
+		if !errors.Is(err, interfaces.ErrAttesterUnavailable) {
+			unavailable = false
+		}
+		logrus.WithError(err).WithField("attester", attester.Name).Warn("attester failed - failing over")
+	}
+	if !unavailable && errors.Is(err, interfaces.ErrAttesterUnavailable) {
+		// Some attester was up and failed, so the fallback should not apply.
+		return nil, fmt.Errorf("attesters failed: %v", err)
+	}
+	return nil, err
+}
+
+// isDefinitiveAttestation tells if the attester has decided about the transaction.
+func isDefinitiveAttestation(err error) bool {
+	var rejected *interfaces.AttestationRejectedError
+	return err == nil || errors.Is(err, interfaces.ErrAttestationNotRequired) || errors.As(err, &rejected)
+}
+
+// CheckHealth implements interfaces.HealthChecker. The set is healthy if any of the
+// attesters which support health checks is healthy.
+func (as *AttesterSet) CheckHealth(ctx context.Context) error {
+	var errs []error
+	for _, attester := range as.attesters {
+		checker, ok := attester.Attester.(interfaces.HealthChecker)
+		if !ok {
+			continue
+		}
+		err := checker.CheckHealth(ctx)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %v", attester.Name, err))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,229 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/forta-network/forta-json-rpc-proxy/interfaces"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxBlockReceiptFetches is the max number of blocks to fetch the receipts of at a
+	// new block. The receipts are looked up by hash if more blocks were missed.
+	maxBlockReceiptFetches = 5
+	// resubscribeDelay is how long the blocks are polled before subscribing again.
+	resubscribeDelay = 30 * time.Second
+)
+
+// BlockWatcher follows the new blocks and resolves the receipts of all of the awaited
+// transactions with a single block receipts fetch per block. The new blocks are received
+// from a newHeads subscription if a WebSocket URL is given, otherwise they are polled.
+type BlockWatcher struct {
+	ethClient    interfaces.EthClient
+	wsURL        string
+	pollInterval time.Duration
+
+	mu        sync.Mutex
+	waiters   map[common.Hash][]chan *types.Receipt
+	lastBlock uint64
+}
+
+// NewBlockWatcher creates a new block watcher.
+func NewBlockWatcher(ethClient interfaces.EthClient, wsURL string, pollInterval time.Duration) *BlockWatcher {
+	return &BlockWatcher{
+		ethClient:    ethClient,
+		wsURL:        wsURL,
+		pollInterval: pollInterval,
+		waiters:      make(map[common.Hash][]chan *types.Receipt),
+	}
+}
+
+// Run follows the new blocks until the context is done. The blocks are polled while
+// the subscription is not available.
+func (bw *BlockWatcher) Run(ctx context.Context) {
+	if len(bw.wsURL) == 0 {
+		bw.poll(ctx, nil)
+		return
+	}
+	for ctx.Err() == nil {
+		err := bw.subscribe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.WithError(err).Warn("new heads subscription failed - polling blocks")
+		bw.poll(ctx, time.After(resubscribeDelay))
+	}
+}
+
+func (bw *BlockWatcher) subscribe(ctx context.Context) error {
+	client, err := ethclient.DialContext(ctx, bw.wsURL)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	heads := make(chan *types.Header, 16)
+	sub, err := client.SubscribeNewHead(ctx, heads)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-sub.Err():
+			return err
+		case head := <-heads:
+			bw.onBlock(ctx, head.Number.Uint64())
+		}
+	}
+}
+
+// poll checks the block number periodically, until the context is done or the
+// stop channel is closed.
+func (bw *BlockWatcher) poll(ctx context.Context, stop <-chan time.Time) {
+	ticker := time.NewTicker(bw.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		// Nothing to poll for.
+		if !bw.hasWaiters() {
+			continue
+		}
+		blockNumber, err := bw.ethClient.BlockNumber(ctx)
+		if err != nil {
+			logrus.WithError(err).Debug("failed to poll block number")
+			continue
+		}
+		bw.onBlock(ctx, blockNumber)
+	}
+}
+
+func (bw *BlockWatcher) hasWaiters() bool {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	return len(bw.waiters) > 0
+}
+
+// onBlock resolves the receipts from the blocks since the last processed block. The
+// blocks which cannot be processed yet, e.g. when the upstream is behind, are retried
+// at the next block.
+func (bw *BlockWatcher) onBlock(ctx context.Context, blockNumber uint64) {
+	bw.mu.Lock()
+	lastBlock := bw.lastBlock
+	waiting := len(bw.waiters)
+	bw.mu.Unlock()
+	if blockNumber <= lastBlock {
+		return
+	}
+	if waiting == 0 {
+		bw.setLastBlock(blockNumber)
+		return
+	}
+
+	// The receipts in the older missed blocks are looked up by hash.
+	if blockNumber-lastBlock > maxBlockReceiptFetches {
+		bw.lookUpReceipts(ctx)
+		lastBlock = blockNumber - maxBlockReceiptFetches
+		bw.setLastBlock(lastBlock)
+	}
+	for n := lastBlock + 1; n <= blockNumber; n++ {
+		receipts, err := bw.ethClient.BlockReceipts(ctx, rpc.BlockNumberOrHashWithNumber(rpc.BlockNumber(n)))
+		if err != nil {
+			logrus.WithError(err).WithField("blockNumber", n).Debug("failed to get block receipts - looking up by hash")
+			bw.lookUpReceipts(ctx)
+			return
+		}
+		for _, receipt := range receipts {
+			bw.resolve(receipt)
+		}
+		bw.setLastBlock(n)
+	}
+}
+
+func (bw *BlockWatcher) setLastBlock(blockNumber uint64) {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	bw.lastBlock = blockNumber
+}
+
+// lookUpReceipts gets the receipts of the awaited transactions one by one.
+func (bw *BlockWatcher) lookUpReceipts(ctx context.Context) {
+	bw.mu.Lock()
+	txHashes := make([]common.Hash, 0, len(bw.waiters))
+	for txHash := range bw.waiters {
+		txHashes = append(txHashes, txHash)
+	}
+	bw.mu.Unlock()
+	for _, txHash := range txHashes {
+		receipt, err := bw.ethClient.TransactionReceipt(ctx, txHash)
+		if err != nil {
+			if !errors.Is(err, ethereum.NotFound) {
+				logrus.WithError(err).WithField("txHash", txHash).Debug("failed to get receipt")
+			}
+			continue
+		}
+		bw.resolve(receipt)
+	}
+}
+
+func (bw *BlockWatcher) resolve(receipt *types.Receipt) {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	for _, ch := range bw.waiters[receipt.TxHash] {
+		ch <- receipt
+	}
+	delete(bw.waiters, receipt.TxHash)
+}
+
+// WaitForReceipt blocks until the receipt of the transaction is found or the context
+// is done.
+func (bw *BlockWatcher) WaitForReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	ch := make(chan *types.Receipt, 1)
+	bw.mu.Lock()
+	bw.waiters[txHash] = append(bw.waiters[txHash], ch)
+	bw.mu.Unlock()
+	defer bw.removeWaiter(txHash, ch)
+
+	// The transaction might be in a block which is already processed.
+	if receipt, err := bw.ethClient.TransactionReceipt(ctx, txHash); err == nil {
+		return receipt, nil
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case receipt := <-ch:
+		return receipt, nil
+	}
+}
+
+func (bw *BlockWatcher) removeWaiter(txHash common.Hash, ch chan *types.Receipt) {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	waiters := bw.waiters[txHash]
+	for i, waiter := range waiters {
+		if waiter == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(bw.waiters, txHash)
+	} else {
+		bw.waiters[txHash] = waiters
+	}
+}
@@ -0,0 +1,212 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// fakeEthClient mines the transactions which have a receipt status set, as soon as
+// they are sent.
+type fakeEthClient struct {
+	mu            sync.Mutex
+	blockNumber   uint64
+	blockReceipts map[uint64][]*types.Receipt
+	blockErr      error
+	blockFailures map[uint64]int
+	hideReceipts  bool
+	receipts      map[common.Hash]*types.Receipt
+	statuses      map[common.Hash]uint64
+	sendErrs      map[common.Hash]error
+	sent          []common.Hash
+}
+
+func newFakeEthClient() *fakeEthClient {
+	return &fakeEthClient{
+		blockReceipts: make(map[uint64][]*types.Receipt),
+		blockFailures: make(map[uint64]int),
+		receipts:      make(map[common.Hash]*types.Receipt),
+		statuses:      make(map[common.Hash]uint64),
+		sendErrs:      make(map[common.Hash]error),
+	}
+}
+
+func (c *fakeEthClient) mine(txHash common.Hash, status uint64) *types.Receipt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blockNumber++
+	receipt := &types.Receipt{TxHash: txHash, Status: status, BlockNumber: new(big.Int).SetUint64(c.blockNumber)}
+	c.receipts[txHash] = receipt
+	c.blockReceipts[c.blockNumber] = []*types.Receipt{receipt}
+	return receipt
+}
+
+func (c *fakeEthClient) sentTxs() []common.Hash {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]common.Hash{}, c.sent...)
+}
+
+func (c *fakeEthClient) BlockNumber(ctx context.Context) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.blockNumber, nil
+}
+
+func (c *fakeEthClient) BlockReceipts(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) ([]*types.Receipt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.blockErr != nil {
+		return nil, c.blockErr
+	}
+	blockNumber, _ := blockNrOrHash.Number()
+	if c.blockFailures[uint64(blockNumber)] > 0 {
+		c.blockFailures[uint64(blockNumber)]--
+		return nil, ethereum.NotFound
+	}
+	return c.blockReceipts[uint64(blockNumber)], nil
+}
+
+func (c *fakeEthClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *fakeEthClient) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *fakeEthClient) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	return 0, errors.New("not implemented")
+}
+
+func (c *fakeEthClient) SendRawTransaction(ctx context.Context, rawTx hexutil.Bytes) (common.Hash, error) {
+	tx, err := decodeTx(rawTx)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	c.mu.Lock()
+	c.sent = append(c.sent, tx.Hash())
+	status, ok := c.statuses[tx.Hash()]
+	_, mined := c.receipts[tx.Hash()]
+	err = c.sendErrs[tx.Hash()]
+	c.mu.Unlock()
+	if ok && !mined {
+		c.mine(tx.Hash(), status)
+	}
+	return tx.Hash(), err
+}
+
+func (c *fakeEthClient) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	receipt, ok := c.receipts[txHash]
+	if !ok || c.hideReceipts {
+		return nil, ethereum.NotFound
+	}
+	return receipt, nil
+}
+
+func TestBlockWatcherOnBlock(t *testing.T) {
+	txHash := common.HexToHash("0x01")
+	tests := []struct {
+		name      string
+		lastBlock uint64
+		heads     []uint64
+		blockErr  error
+		// blockFailures is the number of times the block receipts of block 2 fail.
+		blockFailures int
+		hideReceipts  bool
+	}{
+		{name: "block receipts", lastBlock: 1, heads: []uint64{2}, hideReceipts: true},
+		{name: "first block", heads: []uint64{2}, hideReceipts: true},
+		{name: "block receipts failure", lastBlock: 1, heads: []uint64{2}, blockErr: errors.New("not supported")},
+		{name: "block receipts retried", lastBlock: 1, heads: []uint64{2, 3}, blockFailures: 1, hideReceipts: true},
+		{name: "too many missed blocks", lastBlock: 1, heads: []uint64{2 + maxBlockReceiptFetches}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ethClient := newFakeEthClient()
+			ethClient.blockNumber = 1
+			ethClient.blockErr = test.blockErr
+			ethClient.blockFailures[2] = test.blockFailures
+			bw := NewBlockWatcher(ethClient, "", time.Hour)
+			bw.lastBlock = test.lastBlock
+			ch := make(chan *types.Receipt, 1)
+			bw.waiters[txHash] = []chan *types.Receipt{ch}
+
+			ethClient.mine(txHash, types.ReceiptStatusSuccessful)
+			ethClient.hideReceipts = test.hideReceipts
+			for i, head := range test.heads {
+				bw.onBlock(context.Background(), head)
+				if i < len(test.heads)-1 && !bw.hasWaiters() {
+					t.Fatalf("receipt is resolved before head %d", test.heads[i+1])
+				}
+			}
+
+			select {
+			case receipt := <-ch:
+				if receipt.TxHash != txHash {
+					t.Fatalf("unexpected receipt of %s", receipt.TxHash)
+				}
+			default:
+				t.Fatal("receipt is not resolved")
+			}
+			if bw.hasWaiters() {
+				t.Fatal("waiter is not removed")
+			}
+		})
+	}
+}
+
+func TestBlockWatcherWaitForReceipt(t *testing.T) {
+	txHash := common.HexToHash("0x01")
+	tests := []struct {
+		name        string
+		minedBefore bool
+	}{
+		{name: "already mined", minedBefore: true},
+		{name: "mined in new block"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ethClient := newFakeEthClient()
+			bw := NewBlockWatcher(ethClient, "", 5*time.Millisecond)
+			bw.lastBlock = 1
+			ethClient.blockNumber = 1
+			if test.minedBefore {
+				ethClient.mine(txHash, types.ReceiptStatusSuccessful)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			go bw.Run(ctx)
+			if !test.minedBefore {
+				go func() {
+					for !bw.hasWaiters() {
+						time.Sleep(time.Millisecond)
+					}
+					ethClient.mine(txHash, types.ReceiptStatusSuccessful)
+				}()
+			}
+
+			receipt, err := bw.WaitForReceipt(ctx, txHash)
+			if err != nil {
+				t.Fatalf("failed to wait for receipt: %v", err)
+			}
+			if receipt.TxHash != txHash {
+				t.Fatalf("unexpected receipt of %s", receipt.TxHash)
+			}
+			if bw.hasWaiters() {
+				t.Fatal("waiter is not removed")
+			}
+		})
+	}
+}
@@ -3,29 +3,70 @@ package clients
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/url"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/forta-network/forta-json-rpc-proxy/interfaces"
+	"github.com/forta-network/forta-json-rpc-proxy/metrics"
 )
 
 type builderClient struct {
-	rpcClient *rpc.Client
+	rpcClient  *rpc.Client
+	builderURL *url.URL
 }
 
+var _ interfaces.HealthChecker = &builderClient{}
+
 // NewBuilderClient creates a new bundler client which sends bundles to a builder.
 func NewBuilderClient(ctx context.Context, rawUrl string) (*builderClient, error) {
+	builderURL, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse builder url: %v", err)
+	}
 	c, err := rpc.DialContext(ctx, rawUrl)
 	if err != nil {
 		return nil, fmt.Errorf("failed to dial builder rpc: %v", err)
 	}
-	return &builderClient{rpcClient: c}, nil
+	return &builderClient{rpcClient: c, builderURL: builderURL}, nil
+}
+
+// CheckHealth implements interfaces.HealthChecker. The builder is considered healthy
+// if a connection can be established.
+func (bc *builderClient) CheckHealth(ctx context.Context) error {
+	port := bc.builderURL.Port()
+	if len(port) == 0 {
+		port = "80"
+		if bc.builderURL.Scheme == "https" || bc.builderURL.Scheme == "wss" {
+			port = "443"
+		}
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(bc.builderURL.Hostname(), port))
+	if err != nil {
+		return fmt.Errorf("builder is not dialable: %v", err)
+	}
+	conn.Close()
+	return nil
+}
+
+// Name implements interfaces.Bundler.
+func (bc *builderClient) Name() string {
+	return "builder"
 }
 
 // SendBundle sends a bundle of transactions to a builder.
 func (bc *builderClient) SendBundle(ctx context.Context, txs []hexutil.Bytes) error {
-	return bc.rpcClient.CallContext(ctx, nil, "eth_sendBundle", struct {
+	err := bc.rpcClient.CallContext(ctx, nil, "eth_sendBundle", struct {
 		Txs []hexutil.Bytes `json:"txs"`
 	}{
 		Txs: txs,
 	})
+	if err != nil {
+		metrics.BundlerOutcomes.WithLabelValues(bc.Name(), metrics.BundlerOutcomeFailed).Inc()
+		return err
+	}
+	metrics.BundlerOutcomes.WithLabelValues(bc.Name(), metrics.BundlerOutcomeSent).Inc()
+	return nil
 }
@@ -0,0 +1,68 @@
+package clients
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitBreaker stops the calls to a dependency after consecutive failures and lets
+// a single trial call through after the cooldown. A nil breaker never opens.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// NewCircuitBreaker creates a new circuit breaker which opens after the threshold number
+// of consecutive failures. Zero threshold disables the breaker.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Allow tells if a call can be made.
+func (cb *CircuitBreaker) Allow() bool {
+	if cb == nil {
+		return true
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.failures < cb.threshold {
+		return true
+	}
+	if time.Now().Before(cb.openUntil) {
+		return false
+	}
+	// Let the trial call through and keep the rest out until it completes or
+	// the cooldown passes again.
+	cb.openUntil = time.Now().Add(cb.cooldown)
+	return true
+}
+
+// Success closes the breaker.
+func (cb *CircuitBreaker) Success() {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures = 0
+}
+
+// Failure counts a failure and opens the breaker if the threshold is reached.
+func (cb *CircuitBreaker) Failure() {
+	if cb == nil {
+		return
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures++
+	if cb.failures >= cb.threshold {
+		cb.openUntil = time.Now().Add(cb.cooldown)
+	}
+}
@@ -4,59 +4,302 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/forta-network/forta-json-rpc-proxy/interfaces"
+	"github.com/forta-network/forta-json-rpc-proxy/metrics"
 	"github.com/sirupsen/logrus"
 )
 
+// Bundle failures
+const (
+	BundleFailureSend        = "send"
+	BundleFailureUnconfirmed = "unconfirmed"
+	BundleFailureReverted    = "reverted"
+)
+
+var errTxReverted = errors.New("tx reverted")
+
 type txSender struct {
+	ctx           context.Context
 	ethClient     interfaces.EthClient
+	store         interfaces.BundleStore
+	watcher       *BlockWatcher
 	retryTimes    int
 	retryInterval time.Duration
+	rebroadcast   bool
+	abortOn       []string
 }
 
 var _ interfaces.Bundler = &txSender{}
 
-// NewTxSender creates a new bundler client which sends transactions in order.
-func NewTxSender(ethClient interfaces.EthClient, retryTimes int, retryIntervalSeconds int) *txSender {
+// NewTxSender creates a new bundler client which sends transactions in order. The
+// pending bundles are kept in the store, if given, so that they can be recovered
+// after a restart. The receipts are awaited with the block watcher. The rest of a
+// bundle is not sent after the failures to abort on. The sending stops when the given
+// context is done.
+func NewTxSender(
+	ctx context.Context, ethClient interfaces.EthClient, store interfaces.BundleStore, watcher *BlockWatcher,
+	retryTimes int, retryIntervalSeconds int, rebroadcast bool, abortOn []string,
+) (*txSender, error) {
+	for _, failure := range abortOn {
+		switch failure {
+		case BundleFailureSend, BundleFailureUnconfirmed, BundleFailureReverted:
+		default:
+			return nil, fmt.Errorf("unknown bundle failure %q", failure)
+		}
+	}
 	return &txSender{
+		ctx:           ctx,
 		ethClient:     ethClient,
+		store:         store,
+		watcher:       watcher,
 		retryTimes:    retryTimes,
 		retryInterval: time.Duration(retryIntervalSeconds) * time.Second,
-	}
+		rebroadcast:   rebroadcast,
+		abortOn:       abortOn,
+	}, nil
+}
+
+// Name implements interfaces.Bundler.
+func (ts *txSender) Name() string {
+	return "tx_sender"
 }
 
 // SendBundle sends a bundle of transactions in correct order, one after another.
-// Currently it is implemented to support only two transactions.
+// Every transaction is sent after the previous one is confirmed, except the last
+// one which is not waited for. A bundle which is already in the store is resumed
+// from where it was left off. The bundle is kept in the store if the context is done
+// or the tx sender is shut down before it is sent, so that it can be resumed later.
 func (ts *txSender) SendBundle(ctx context.Context, txs []hexutil.Bytes) error {
-	if len(txs) != 2 {
-		return errors.New("unexpected bundle size")
+	if len(txs) == 0 {
+		err := errors.New("empty bundle")
+		ts.observe(err)
+		return err
 	}
-	txHash, err := ts.ethClient.SendRawTransaction(ctx, txs[0])
+	lastTx, err := decodeTx(txs[len(txs)-1])
 	if err != nil {
-		return fmt.Errorf("failed to send first tx: %v", err)
+		ts.observe(err)
+		return err
+	}
+	bundle := ts.loadBundle(lastTx.Hash())
+	resumed := bundle != nil
+	if bundle == nil {
+		bundle = &interfaces.PendingBundle{ID: lastTx.Hash(), Txs: txs, CreatedAt: time.Now()}
+		ts.saveBundle(bundle)
 	}
 
-	// Wait for just a second.
-	time.Sleep(time.Second)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ts.ctx, cancel)
+	defer stop()
+	err = ts.send(ctx, bundle, resumed)
+	ts.observe(err)
+	return err
+}
+
+func (ts *txSender) observe(err error) {
+	outcome := metrics.BundlerOutcomeSent
+	if err != nil {
+		outcome = metrics.BundlerOutcomeFailed
+	}
+	metrics.BundlerOutcomes.WithLabelValues(ts.Name(), outcome).Inc()
+}
+
+// send sends the rest of the bundle transactions which were not sent yet. The failures
+// abort the rest of the bundle as configured. A resumed bundle may have its next
+// transaction sent already if the sending was interrupted before it was recorded.
+func (ts *txSender) send(ctx context.Context, bundle *interfaces.PendingBundle, resumed bool) (err error) {
+	defer func() {
+		// Keep the bundle if the sending is interrupted, to recover later.
+		if ctx.Err() == nil {
+			ts.removeBundle(bundle)
+		}
+	}()
 
-	// Try to get the receipt of the first tx.
-	for i := 0; i < ts.retryTimes; i++ {
-		receipt, err := ts.ethClient.TransactionReceipt(ctx, txHash)
+	// The transactions before the last sent one are already confirmed.
+	for i := max(bundle.Sent-1, 0); i < len(bundle.Txs); i++ {
+		tx, err := decodeTx(bundle.Txs[i])
 		if err != nil {
-			logrus.WithError(err).Debug("failed to get first tx receipt - will retry")
-			time.Sleep(ts.retryInterval)
+			return err
+		}
+		logger := logrus.WithFields(logrus.Fields{"bundleId": bundle.ID, "txIndex": i, "txHash": tx.Hash()})
+
+		if i >= bundle.Sent {
+			var err error
+			if !resumed || !ts.isMined(ctx, tx) {
+				_, err = ts.ethClient.SendRawTransaction(ctx, bundle.Txs[i])
+			}
+			resumed = false
+			if err != nil && isAlreadyKnown(err) {
+				logger.WithError(err).Debug("bundle tx is already sent")
+				err = nil
+			}
+			if err != nil {
+				err = fmt.Errorf("failed to send tx %d: %v", i, err)
+				// The bundle is not sent at all without the last tx.
+				if i == len(bundle.Txs)-1 || ts.aborts(ctx, BundleFailureSend) {
+					return err
+				}
+				logger.WithError(err).Warn("failed to send bundle tx - continuing")
+				continue
+			}
+			bundle.Sent = i + 1
+			ts.saveBundle(bundle)
+		}
+		if i == len(bundle.Txs)-1 {
+			break
+		}
+
+		err = ts.waitForReceipt(ctx, tx, bundle.Txs[i], time.Now())
+		switch {
+		case err == nil:
 			continue
+		case errors.Is(err, errTxReverted):
+			if ts.aborts(ctx, BundleFailureReverted) {
+				return err
+			}
+		case errors.Is(err, interfaces.ErrAttestationNotConfirmed):
+			if ts.aborts(ctx, BundleFailureUnconfirmed) {
+				return err
+			}
+		default:
+			return err
 		}
-		if receipt.Status != 1 {
-			return fmt.Errorf("first tx failed: %s", txHash.Hex())
+		logger.WithError(err).Warn("bundle tx is not confirmed successfully - continuing")
+	}
+	return nil
+}
+
+// isMined tells if the transaction has a receipt already.
+func (ts *txSender) isMined(ctx context.Context, tx *types.Transaction) bool {
+	receipt, err := ts.ethClient.TransactionReceipt(ctx, tx.Hash())
+	return err == nil && receipt != nil
+}
+
+// isAlreadyKnown tells if the upstream has refused the transaction because it has
+// seen it already.
+func isAlreadyKnown(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "already known")
+}
+
+// aborts tells if the failure should abort the rest of the bundle.
+func (ts *txSender) aborts(ctx context.Context, failure string) bool {
+	return ctx.Err() != nil || slices.Contains(ts.abortOn, failure)
+}
+
+// waitForReceipt waits until the transaction is confirmed successfully, for up to the
+// retry times and interval. The transaction is rebroadcast at every interval if enabled,
+// in case the upstream has dropped it.
+func (ts *txSender) waitForReceipt(ctx context.Context, tx *types.Transaction, rawTx hexutil.Bytes, sentAt time.Time) error {
+	waitCtx, cancel := context.WithTimeout(ctx, time.Duration(ts.retryTimes)*ts.retryInterval)
+	defer cancel()
+	if ts.rebroadcast {
+		go ts.rebroadcastEvery(waitCtx, rawTx)
+	}
+	receipt, err := ts.watcher.WaitForReceipt(waitCtx, tx.Hash())
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-		break
+		return fmt.Errorf("%w: %s", interfaces.ErrAttestationNotConfirmed, tx.Hash().Hex())
 	}
+	confirmedIn := time.Since(sentAt)
+	metrics.ReceiptWaitDuration.Observe(confirmedIn.Seconds())
+	logrus.WithFields(logrus.Fields{
+		"txHash":      tx.Hash(),
+		"confirmedIn": confirmedIn.String(),
+	}).Debug("bundle tx confirmed")
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return fmt.Errorf("%w: %s", errTxReverted, tx.Hash().Hex())
+	}
+	return nil
+}
 
-	// Send the second transaction and forget about it.
-	_, err = ts.ethClient.SendRawTransaction(ctx, txs[1])
-	return err
+func (ts *txSender) rebroadcastEvery(ctx context.Context, rawTx hexutil.Bytes) {
+	for sleepContext(ctx, ts.retryInterval) == nil {
+		if _, err := ts.ethClient.SendRawTransaction(ctx, rawTx); err != nil {
+			logrus.WithError(err).Debug("failed to rebroadcast bundle tx")
+		}
+	}
+}
+
+// sleepContext sleeps until the duration passes or the context is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// LoadPendingBundles reads the pending bundles from the store, in the order they were
+// created. The bundles older than the max age are abandoned.
+func LoadPendingBundles(store interfaces.BundleStore, maxAge time.Duration) []*interfaces.PendingBundle {
+	bundles, err := store.Pending()
+	if err != nil {
+		logrus.WithError(err).Error("failed to read pending bundles")
+		return nil
+	}
+	slices.SortFunc(bundles, func(a, b *interfaces.PendingBundle) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	var pending []*interfaces.PendingBundle
+	for _, bundle := range bundles {
+		if len(bundle.Txs) > 0 && time.Since(bundle.CreatedAt) <= maxAge {
+			pending = append(pending, bundle)
+			continue
+		}
+		logrus.WithFields(logrus.Fields{"bundleId": bundle.ID, "sent": bundle.Sent}).Warn("abandoning pending bundle")
+		if err := store.Delete(bundle); err != nil {
+			logrus.WithError(err).WithField("bundleId", bundle.ID).Error("failed to remove pending bundle")
+		}
+	}
+	return pending
+}
+
+func (ts *txSender) loadBundle(id common.Hash) *interfaces.PendingBundle {
+	if ts.store == nil {
+		return nil
+	}
+	bundle, err := ts.store.Get(id)
+	if err != nil {
+		logrus.WithError(err).WithField("bundleId", id).Error("failed to load pending bundle")
+		return nil
+	}
+	return bundle
+}
+
+func (ts *txSender) saveBundle(bundle *interfaces.PendingBundle) {
+	if ts.store == nil {
+		return
+	}
+	if err := ts.store.Put(bundle); err != nil {
+		logrus.WithError(err).WithField("bundleId", bundle.ID).Error("failed to save pending bundle")
+	}
+}
+
+func (ts *txSender) removeBundle(bundle *interfaces.PendingBundle) {
+	if ts.store == nil {
+		return
+	}
+	if err := ts.store.Delete(bundle); err != nil {
+		logrus.WithError(err).WithField("bundleId", bundle.ID).Error("failed to remove pending bundle")
+	}
+}
+
+func decodeTx(rawTx hexutil.Bytes) (*types.Transaction, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(rawTx); err != nil {
+		return nil, fmt.Errorf("failed to decode tx: %v", err)
+	}
+	return tx, nil
 }
@@ -0,0 +1,236 @@
+package clients
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/forta-network/forta-json-rpc-proxy/interfaces"
+)
+
+type memBundleStore struct {
+	mu      sync.Mutex
+	bundles map[common.Hash]interfaces.PendingBundle
+}
+
+func newMemBundleStore() *memBundleStore {
+	return &memBundleStore{bundles: make(map[common.Hash]interfaces.PendingBundle)}
+}
+
+func (s *memBundleStore) Get(id common.Hash) (*interfaces.PendingBundle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bundle, ok := s.bundles[id]
+	if !ok {
+		return nil, nil
+	}
+	return &bundle, nil
+}
+
+func (s *memBundleStore) Put(bundle *interfaces.PendingBundle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bundles[bundle.ID] = *bundle
+	return nil
+}
+
+func (s *memBundleStore) Delete(bundle *interfaces.PendingBundle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.bundles, bundle.ID)
+	return nil
+}
+
+func (s *memBundleStore) Pending() ([]*interfaces.PendingBundle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var bundles []*interfaces.PendingBundle
+	for _, bundle := range s.bundles {
+		bundles = append(bundles, &bundle)
+	}
+	return bundles, nil
+}
+
+func newTestBundle(t *testing.T, n int) ([]hexutil.Bytes, []common.Hash) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		txs    []hexutil.Bytes
+		hashes []common.Hash
+	)
+	for i := 0; i < n; i++ {
+		rawTx, txHash := newTestTx(t, key, uint64(i))
+		txs = append(txs, rawTx)
+		hashes = append(hashes, txHash)
+	}
+	return txs, hashes
+}
+
+func newTestTx(t *testing.T, key *ecdsa.PrivateKey, nonce uint64) (hexutil.Bytes, common.Hash) {
+	chainID := big.NewInt(1)
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(chainID), &types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     nonce,
+		Gas:       21000,
+		GasFeeCap: big.NewInt(1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawTx, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rawTx, tx.Hash()
+}
+
+func newTestTxSender(ethClient *fakeEthClient, store interfaces.BundleStore, abortOn []string) *txSender {
+	return &txSender{
+		ctx:           context.Background(),
+		ethClient:     ethClient,
+		store:         store,
+		watcher:       NewBlockWatcher(ethClient, "", 5*time.Millisecond),
+		retryTimes:    2,
+		retryInterval: 10 * time.Millisecond,
+		abortOn:       abortOn,
+	}
+}
+
+func TestTxSenderAbortPolicy(t *testing.T) {
+	const (
+		ok = iota
+		reverted
+		unconfirmed
+		sendFailure
+	)
+	tests := []struct {
+		name    string
+		txs     []int
+		abortOn []string
+		wantErr error
+		// wantSent is the indexes of the txs which are attempted to be sent.
+		wantSent []int
+	}{
+		{name: "all confirmed", txs: []int{ok, ok, ok}, wantSent: []int{0, 1, 2}},
+		{
+			name: "abort on revert", txs: []int{reverted, ok, ok}, abortOn: []string{BundleFailureReverted},
+			wantErr: errTxReverted, wantSent: []int{0},
+		},
+		{name: "continue on revert", txs: []int{reverted, ok, ok}, wantSent: []int{0, 1, 2}},
+		{
+			name: "abort on unconfirmed", txs: []int{ok, unconfirmed, ok}, abortOn: []string{BundleFailureUnconfirmed},
+			wantErr: interfaces.ErrAttestationNotConfirmed, wantSent: []int{0, 1},
+		},
+		{name: "continue on unconfirmed", txs: []int{unconfirmed, ok}, wantSent: []int{0, 1}},
+		{
+			name: "abort on send failure", txs: []int{ok, sendFailure, ok}, abortOn: []string{BundleFailureSend},
+			wantErr: errors.New("failed to send tx 1: nonce too low"), wantSent: []int{0, 1},
+		},
+		{name: "continue on send failure", txs: []int{sendFailure, ok}, wantSent: []int{0, 1}},
+		{
+			name: "last tx send failure", txs: []int{ok, sendFailure},
+			wantErr: errors.New("failed to send tx 1: nonce too low"), wantSent: []int{0, 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			txs, hashes := newTestBundle(t, len(test.txs))
+			ethClient := newFakeEthClient()
+			for i, outcome := range test.txs {
+				switch outcome {
+				case ok:
+					ethClient.statuses[hashes[i]] = types.ReceiptStatusSuccessful
+				case reverted:
+					ethClient.statuses[hashes[i]] = types.ReceiptStatusFailed
+				case sendFailure:
+					ethClient.sendErrs[hashes[i]] = errors.New("nonce too low")
+				}
+			}
+			store := newMemBundleStore()
+			ts := newTestTxSender(ethClient, store, test.abortOn)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			go ts.watcher.Run(ctx)
+
+			err := ts.SendBundle(ctx, txs)
+			switch {
+			case test.wantErr == nil && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case test.wantErr != nil && !errors.Is(err, test.wantErr) && (err == nil || err.Error() != test.wantErr.Error()):
+				t.Fatalf("expected error %v, got %v", test.wantErr, err)
+			}
+			var wantSent []common.Hash
+			for _, i := range test.wantSent {
+				wantSent = append(wantSent, hashes[i])
+			}
+			if sent := ethClient.sentTxs(); !slices.Equal(sent, wantSent) {
+				t.Fatalf("expected sent txs %v, got %v", wantSent, sent)
+			}
+			if pending, _ := store.Pending(); len(pending) > 0 {
+				t.Fatal("bundle is not removed from the store")
+			}
+		})
+	}
+}
+
+func TestTxSenderResume(t *testing.T) {
+	tests := []struct {
+		name string
+		sent int
+		// mined is the number of txs which were confirmed before resuming.
+		mined        int
+		alreadyKnown bool
+		wantSent     []int
+	}{
+		{name: "nothing sent", wantSent: []int{0, 1, 2}},
+		{name: "last sent tx awaited", sent: 2, mined: 2, wantSent: []int{2}},
+		{name: "sent but not recorded", sent: 1, mined: 2, wantSent: []int{2}},
+		{name: "sent but not mined", sent: 1, mined: 1, alreadyKnown: true, wantSent: []int{1, 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			txs, hashes := newTestBundle(t, 3)
+			ethClient := newFakeEthClient()
+			for i, txHash := range hashes {
+				if i < test.mined {
+					ethClient.mine(txHash, types.ReceiptStatusSuccessful)
+				}
+				ethClient.statuses[txHash] = types.ReceiptStatusSuccessful
+			}
+			if test.alreadyKnown {
+				ethClient.sendErrs[hashes[test.sent]] = errors.New("already known")
+			}
+			store := newMemBundleStore()
+			store.Put(&interfaces.PendingBundle{ID: hashes[2], Txs: txs, Sent: test.sent, CreatedAt: time.Now()})
+			ts := newTestTxSender(ethClient, store, []string{BundleFailureSend, BundleFailureUnconfirmed})
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			go ts.watcher.Run(ctx)
+
+			if err := ts.SendBundle(ctx, txs); err != nil {
+				t.Fatalf("failed to resume bundle: %v", err)
+			}
+			var wantSent []common.Hash
+			for _, i := range test.wantSent {
+				wantSent = append(wantSent, hashes[i])
+			}
+			if sent := ethClient.sentTxs(); !slices.Equal(sent, wantSent) {
+				t.Fatalf("expected sent txs %v, got %v", wantSent, sent)
+			}
+			if pending, _ := store.Pending(); len(pending) > 0 {
+				t.Fatal("bundle is not removed from the store")
+			}
+		})
+	}
+}
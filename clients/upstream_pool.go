@@ -0,0 +1,389 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/forta-network/forta-json-rpc-proxy/interfaces"
+	"github.com/forta-network/forta-json-rpc-proxy/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const upstreamProbeTimeout = 5 * time.Second
+
+type upstream struct {
+	url       *url.URL
+	weight    int
+	ethClient *ethClient
+
+	mu          sync.RWMutex
+	healthy     bool
+	blockNumber uint64
+	latency     time.Duration
+}
+
+func (u *upstream) isHealthy() bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.healthy
+}
+
+func (u *upstream) setHealthy(healthy bool) {
+	u.mu.Lock()
+	u.healthy = healthy
+	u.mu.Unlock()
+}
+
+// UpstreamPool is a set of upstream JSON-RPC endpoints which are health-checked
+// periodically. The calls are sent to the healthy upstreams first and fail over to
+// the next upstream on network errors.
+type UpstreamPool struct {
+	chainID     *big.Int
+	upstreams   []*upstream
+	weighted    bool
+	maxBlockLag uint64
+	maxLatency  time.Duration
+}
+
+var (
+	_ interfaces.RPCClient        = &UpstreamPool{}
+	_ interfaces.EthClient        = &UpstreamPool{}
+	_ interfaces.UpstreamSelector = &UpstreamPool{}
+	_ interfaces.HealthChecker    = &UpstreamPool{}
+)
+
+// NewUpstreamPool dials the upstreams and probes them once. The chain ID is read from
+// the first upstream that responds and all upstreams are expected to match it. If the
+// weights are given, the calls are load balanced among the healthy upstreams by weight.
+// Otherwise, the upstreams are used in the given order of priority.
+func NewUpstreamPool(
+	ctx context.Context, rawUrls []string, weights []int, maxBlockLag uint64, maxLatency time.Duration,
+) (*UpstreamPool, error) {
+	if len(rawUrls) == 0 {
+		return nil, errors.New("no upstreams")
+	}
+	if len(weights) > 0 && len(weights) != len(rawUrls) {
+		return nil, fmt.Errorf("expected %d upstream weights but got %d", len(rawUrls), len(weights))
+	}
+	pool := &UpstreamPool{
+		weighted:    len(weights) > 0,
+		maxBlockLag: maxBlockLag,
+		maxLatency:  maxLatency,
+	}
+	for i, rawUrl := range rawUrls {
+		u, err := url.Parse(rawUrl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse upstream url: %v", err)
+		}
+		c, err := ethclient.DialContext(ctx, rawUrl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dial upstream %s: %v", u.Host, err)
+		}
+		weight := 1
+		if pool.weighted {
+			weight = weights[i]
+			if weight <= 0 {
+				return nil, fmt.Errorf("upstream weight must be positive: %s", u.Host)
+			}
+		}
+		pool.upstreams = append(pool.upstreams, &upstream{
+			url:       u,
+			weight:    weight,
+			ethClient: NewEthClient(c),
+		})
+	}
+
+	for _, u := range pool.upstreams {
+		probeCtx, cancel := context.WithTimeout(ctx, upstreamProbeTimeout)
+		chainID, err := u.ethClient.ChainID(probeCtx)
+		cancel()
+		if err == nil {
+			pool.chainID = chainID
+			break
+		}
+		logrus.WithError(err).WithField("upstream", u.url.Host).Warn("failed to get chain id from upstream")
+	}
+	if pool.chainID == nil {
+		return nil, errors.New("failed to get chain id from any upstream")
+	}
+	pool.probe(ctx)
+	return pool, nil
+}
+
+// ChainID returns the chain ID of the upstreams.
+func (pool *UpstreamPool) ChainID() *big.Int {
+	return pool.chainID
+}
+
+// Run probes the upstreams periodically, until the context is done.
+func (pool *UpstreamPool) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pool.probe(ctx)
+	}
+}
+
+// probe checks the chain ID, the block number and the latency of every upstream and
+// then marks the upstreams which fall behind the highest block as unhealthy.
+func (pool *UpstreamPool) probe(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, u := range pool.upstreams {
+		wg.Add(1)
+		go func(u *upstream) {
+			defer wg.Done()
+			pool.probeUpstream(ctx, u)
+		}(u)
+	}
+	wg.Wait()
+
+	var highest uint64
+	for _, u := range pool.upstreams {
+		u.mu.RLock()
+		if u.healthy && u.blockNumber > highest {
+			highest = u.blockNumber
+		}
+		u.mu.RUnlock()
+	}
+	for _, u := range pool.upstreams {
+		u.mu.Lock()
+		if u.healthy && highest-u.blockNumber > pool.maxBlockLag {
+			logrus.WithFields(logrus.Fields{
+				"upstream":    u.url.Host,
+				"blockNumber": u.blockNumber,
+				"highest":     highest,
+			}).Warn("upstream is lagging behind")
+			u.healthy = false
+		}
+		u.mu.Unlock()
+	}
+}
+
+func (pool *UpstreamPool) probeUpstream(ctx context.Context, u *upstream) {
+	ctx, cancel := context.WithTimeout(ctx, upstreamProbeTimeout)
+	defer cancel()
+	logger := logrus.WithField("upstream", u.url.Host)
+
+	start := time.Now()
+	blockNumber, err := u.ethClient.BlockNumber(ctx)
+	latency := time.Since(start)
+	if err != nil {
+		logger.WithError(err).Warn("failed to probe upstream block number")
+		u.setHealthy(false)
+		return
+	}
+	chainID, err := u.ethClient.ChainID(ctx)
+	if err != nil {
+		logger.WithError(err).Warn("failed to probe upstream chain id")
+		u.setHealthy(false)
+		return
+	}
+
+	healthy := true
+	if chainID.Cmp(pool.chainID) != 0 {
+		logger.WithField("chainId", chainID).Error("upstream chain id mismatch")
+		healthy = false
+	}
+	if pool.maxLatency > 0 && latency > pool.maxLatency {
+		logger.WithField("latency", latency).Warn("upstream is too slow")
+		healthy = false
+	}
+
+	u.mu.Lock()
+	u.healthy = healthy
+	u.blockNumber = blockNumber
+	u.latency = latency
+	u.mu.Unlock()
+}
+
+// candidates returns the healthy upstreams in the order of preference, followed
+// by the unhealthy ones as the last resort.
+func (pool *UpstreamPool) candidates() []*upstream {
+	var healthy, unhealthy []*upstream
+	for _, u := range pool.upstreams {
+		if u.isHealthy() {
+			healthy = append(healthy, u)
+		} else {
+			unhealthy = append(unhealthy, u)
+		}
+	}
+	if pool.weighted {
+		healthy = shuffleByWeight(healthy)
+	}
+	return append(healthy, unhealthy...)
+}
+
+// shuffleByWeight orders the upstreams by weighted random sampling.
+func shuffleByWeight(upstreams []*upstream) []*upstream {
+	var total int
+	for _, u := range upstreams {
+		total += u.weight
+	}
+	remaining := append([]*upstream{}, upstreams...)
+	ordered := make([]*upstream, 0, len(upstreams))
+	for len(remaining) > 0 {
+		n := rand.Intn(total)
+		for i, u := range remaining {
+			if n < u.weight {
+				ordered = append(ordered, u)
+				total -= u.weight
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+			n -= u.weight
+		}
+	}
+	return ordered
+}
+
+// Candidates implements interfaces.UpstreamSelector.
+func (pool *UpstreamPool) Candidates() []*url.URL {
+	var urls []*url.URL
+	for _, u := range pool.candidates() {
+		urls = append(urls, u.url)
+	}
+	return urls
+}
+
+// MarkFailed implements interfaces.UpstreamSelector. The upstream is excluded
+// from the healthy upstreams until the next successful probe.
+func (pool *UpstreamPool) MarkFailed(failed *url.URL) {
+	for _, u := range pool.upstreams {
+		if u.url == failed {
+			logrus.WithField("upstream", u.url.Host).Warn("marking upstream as failed")
+			u.setHealthy(false)
+			return
+		}
+	}
+}
+
+// do runs the call on the upstreams until one of them gives back a response.
+func (pool *UpstreamPool) do(ctx context.Context, call func(ec *ethClient) error) (err error) {
+	for _, u := range pool.candidates() {
+		metrics.UpstreamRequests.WithLabelValues(u.url.Host).Inc()
+		err = call(u.ethClient)
+		if !shouldFailover(ctx, err) {
+			return err
+		}
+		metrics.UpstreamErrors.WithLabelValues(u.url.Host).Inc()
+		logrus.WithError(err).WithField("upstream", u.url.Host).Warn("upstream call failed - failing over")
+		u.setHealthy(false)
+	}
+	return err
+}
+
+// shouldFailover tells if the error is about the upstream instead of the call.
+func shouldFailover(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil || errors.Is(err, ethereum.NotFound) {
+		return false
+	}
+	var rpcErr rpc.Error
+	return !errors.As(err, &rpcErr)
+}
+
+// CheckHealth implements interfaces.HealthChecker. The pool is healthy if an upstream
+// responds with the expected chain ID.
+func (pool *UpstreamPool) CheckHealth(ctx context.Context) error {
+	var chainID hexutil.Big
+	if err := pool.CallContext(ctx, &chainID, "eth_chainId"); err != nil {
+		return fmt.Errorf("failed to get chain id: %v", err)
+	}
+	if chainID.ToInt().Cmp(pool.chainID) != 0 {
+		return fmt.Errorf("unexpected chain id %s", chainID.ToInt())
+	}
+	return nil
+}
+
+// Close implements interfaces.RPCClient.
+func (pool *UpstreamPool) Close() {
+	for _, u := range pool.upstreams {
+		u.ethClient.Close()
+	}
+}
+
+// CallContext implements interfaces.RPCClient.
+func (pool *UpstreamPool) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	return pool.do(ctx, func(ec *ethClient) error {
+		return ec.Client.Client().CallContext(ctx, result, method, args...)
+	})
+}
+
+// BlockNumber implements interfaces.EthClient.
+func (pool *UpstreamPool) BlockNumber(ctx context.Context) (blockNumber uint64, err error) {
+	err = pool.do(ctx, func(ec *ethClient) (err error) {
+		blockNumber, err = ec.BlockNumber(ctx)
+		return
+	})
+	return
+}
+
+// BlockReceipts implements interfaces.EthClient.
+func (pool *UpstreamPool) BlockReceipts(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (receipts []*types.Receipt, err error) {
+	err = pool.do(ctx, func(ec *ethClient) (err error) {
+		receipts, err = ec.BlockReceipts(ctx, blockNrOrHash)
+		return
+	})
+	return
+}
+
+// CallContract implements interfaces.EthClient.
+func (pool *UpstreamPool) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) (result []byte, err error) {
+	err = pool.do(ctx, func(ec *ethClient) (err error) {
+		result, err = ec.CallContract(ctx, msg, blockNumber)
+		return
+	})
+	return
+}
+
+// HeaderByNumber implements interfaces.EthClient.
+func (pool *UpstreamPool) HeaderByNumber(ctx context.Context, number *big.Int) (header *types.Header, err error) {
+	err = pool.do(ctx, func(ec *ethClient) (err error) {
+		header, err = ec.HeaderByNumber(ctx, number)
+		return
+	})
+	return
+}
+
+// NonceAt implements interfaces.EthClient.
+func (pool *UpstreamPool) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (nonce uint64, err error) {
+	err = pool.do(ctx, func(ec *ethClient) (err error) {
+		nonce, err = ec.NonceAt(ctx, account, blockNumber)
+		return
+	})
+	return
+}
+
+// SendRawTransaction implements interfaces.EthClient.
+func (pool *UpstreamPool) SendRawTransaction(ctx context.Context, tx hexutil.Bytes) (h common.Hash, err error) {
+	err = pool.do(ctx, func(ec *ethClient) (err error) {
+		h, err = ec.SendRawTransaction(ctx, tx)
+		return
+	})
+	return
+}
+
+// TransactionReceipt implements interfaces.EthClient.
+func (pool *UpstreamPool) TransactionReceipt(ctx context.Context, txHash common.Hash) (receipt *types.Receipt, err error) {
+	err = pool.do(ctx, func(ec *ethClient) (err error) {
+		receipt, err = ec.TransactionReceipt(ctx, txHash)
+		return
+	})
+	return
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"errors"
 	"math/big"
+	"net/url"
+	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
 type RPCClient interface {
@@ -17,29 +20,84 @@ type RPCClient interface {
 }
 
 type EthClient interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	BlockReceipts(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) ([]*types.Receipt, error)
 	CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
 	SendRawTransaction(ctx context.Context, tx hexutil.Bytes) (common.Hash, error)
 	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
 }
 
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+type UpstreamSelector interface {
+	Candidates() []*url.URL
+	MarkFailed(u *url.URL)
+}
+
 type Bundler interface {
+	Name() string
 	SendBundle(ctx context.Context, txs []hexutil.Bytes) error
 }
 
+// PendingBundle is a bundle which is not completely sent yet. Sent is the number of
+// transactions which were already sent, in order.
+type PendingBundle struct {
+	ID        common.Hash     `json:"id"`
+	Txs       []hexutil.Bytes `json:"txs"`
+	Sent      int             `json:"sent"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+var ErrAttestationNotConfirmed = errors.New("attestation not confirmed")
+
+type BundleStore interface {
+	Get(id common.Hash) (*PendingBundle, error)
+	Put(bundle *PendingBundle) error
+	Delete(bundle *PendingBundle) error
+	Pending() ([]*PendingBundle, error)
+}
+
 type AttestRequest struct {
-	From    common.Address `json:"from"`
-	To      common.Address `json:"to"`
-	Input   string         `json:"input"`
-	Value   *hexutil.Big   `json:"value"`
-	ChainID uint64         `json:"chainId"`
+	From                 common.Address   `json:"from"`
+	To                   common.Address   `json:"to"`
+	Input                string           `json:"input"`
+	Value                *hexutil.Big     `json:"value"`
+	ChainID              uint64           `json:"chainId"`
+	Nonce                hexutil.Uint64   `json:"nonce"`
+	Gas                  hexutil.Uint64   `json:"gas"`
+	GasPrice             *hexutil.Big     `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *hexutil.Big     `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big     `json:"maxPriorityFeePerGas,omitempty"`
+	Type                 hexutil.Uint64   `json:"type"`
+	AccessList           types.AccessList `json:"accessList,omitempty"`
+	TxHash               common.Hash      `json:"txHash"`
+	RawTx                hexutil.Bytes    `json:"rawTx"`
+	BlockNumber          hexutil.Uint64   `json:"blockNumber"`
 }
 
 type AttesterError error
 
 var (
 	ErrAttestationNotRequired AttesterError = errors.New("attestation not required")
+	ErrAttesterUnavailable    AttesterError = errors.New("attester unavailable")
 )
 
+// AttestationRejectedError is returned when the attester refuses to attest to a transaction.
+type AttestationRejectedError struct {
+	Code        int
+	Message     string
+	ReferenceID string
+	RiskLabels  []string
+}
+
+func (err *AttestationRejectedError) Error() string {
+	return err.Message
+}
+
 type Attester interface {
 	AttestWithTx(ctx context.Context, req *AttestRequest) (tx hexutil.Bytes, err error)
 }
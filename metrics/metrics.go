@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "forta_json_rpc_proxy"
+
+// Attester outcomes
+const (
+	AttesterOutcomeAttested    = "attested"
+	AttesterOutcomeNotRequired = "not_required"
+	AttesterOutcomeRejected    = "rejected"
+	AttesterOutcomeError       = "error"
+	AttesterOutcomeUnavailable = "unavailable"
+)
+
+// Bundler outcomes
+const (
+	BundlerOutcomeSent   = "sent"
+	BundlerOutcomeFailed = "failed"
+)
+
+var (
+	// Requests counts the JSON-RPC requests per method and route.
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Number of JSON-RPC requests.",
+	}, []string{"method", "route"})
+
+	// RequestErrors counts the JSON-RPC error responses created by the proxy per method and error code.
+	RequestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "request_errors_total",
+		Help:      "Number of JSON-RPC error responses created by the proxy.",
+	}, []string{"method", "code"})
+
+	// RequestDuration observes the JSON-RPC request handling durations per method and route.
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Duration of handling JSON-RPC requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// AttesterOutcomes counts the attester responses per outcome.
+	AttesterOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "attester_outcomes_total",
+		Help:      "Number of attestation requests per outcome.",
+	}, []string{"outcome"})
+
+	// BundlerOutcomes counts the bundles sent per bundler and outcome.
+	BundlerOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bundler_outcomes_total",
+		Help:      "Number of bundles per bundler and outcome.",
+	}, []string{"bundler", "outcome"})
+
+	// ReceiptWaitDuration observes how long it takes to get the receipt of a bundled transaction.
+	ReceiptWaitDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "receipt_wait_duration_seconds",
+		Help:      "Duration of waiting for the receipts of the bundled transactions.",
+		Buckets:   []float64{0.5, 1, 2, 4, 8, 15, 30, 60, 120},
+	})
+
+	// SubmissionQueueLength is the number of bundles waiting in the submission queue.
+	SubmissionQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "submission_queue_length",
+		Help:      "Number of bundles waiting to be sent.",
+	})
+
+	// UpstreamRequests counts the requests sent to the upstreams.
+	UpstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_requests_total",
+		Help:      "Number of requests sent to the upstreams.",
+	}, []string{"upstream"})
+
+	// UpstreamErrors counts the failed requests to the upstreams.
+	UpstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_errors_total",
+		Help:      "Number of failed requests to the upstreams.",
+	}, []string{"upstream"})
+)
@@ -1,24 +1,63 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"time"
 
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/forta-network/forta-json-rpc-proxy/clients"
 	"github.com/forta-network/forta-json-rpc-proxy/interfaces"
 	"github.com/forta-network/forta-json-rpc-proxy/service"
+	"github.com/forta-network/forta-json-rpc-proxy/store"
 	"github.com/forta-network/forta-json-rpc-proxy/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
 	"github.com/sirupsen/logrus"
 )
 
+// serverWriteTimeout is the time limit of handling a request and writing the response.
+const serverWriteTimeout = 15 * time.Second
+
 // Start is a blocking function which initializes internal dependencies, services
 // and the proxy and listens for incoming requests.
 func Start(cfg service.Config) {
-	attesterClient := clients.NewAttesterClient(cfg.AttesterAPIURL, cfg.AttesterAuthToken)
-	StartWithAttester(cfg, attesterClient)
+	var endpoints []*clients.AttesterEndpoint
+	if len(cfg.AttesterAPIURL) > 0 {
+		endpoints = append(endpoints, &clients.AttesterEndpoint{URL: cfg.AttesterAPIURL, Token: cfg.AttesterAuthToken})
+	}
+	if len(cfg.AttestersFile) > 0 {
+		fileEndpoints, err := clients.LoadAttesterEndpoints(cfg.AttestersFile)
+		if err != nil {
+			logrus.WithError(err).Panic("failed to load attesters")
+		}
+		endpoints = append(endpoints, fileEndpoints...)
+	}
+	if len(endpoints) == 0 {
+		logrus.Panic("no attesters configured")
+	}
+	// The attestation should be done before the response can no longer be written.
+	if time.Duration(cfg.AttesterBudgetSeconds)*time.Second >= serverWriteTimeout {
+		logrus.Panicf("attester budget should be less than %s", serverWriteTimeout)
+	}
+
+	var attesters []*clients.PrioritizedAttester
+	for _, endpoint := range endpoints {
+		attesters = append(attesters, &clients.PrioritizedAttester{
+			Name: endpoint.URL,
+			Attester: clients.NewAttesterClient(
+				endpoint.URL, endpoint.Token,
+				time.Duration(cfg.AttesterTimeoutSeconds)*time.Second, cfg.AttesterRetries,
+				time.Duration(cfg.AttesterRetryBackoffMillis)*time.Millisecond,
+				clients.NewCircuitBreaker(cfg.AttesterBreakerThreshold, time.Duration(cfg.AttesterBreakerCooldownSeconds)*time.Second),
+			),
+			Priority: endpoint.Priority,
+			ChainIDs: endpoint.ChainIDs,
+		})
+	}
+	StartWithAttester(cfg, clients.NewAttesterSet(attesters, time.Duration(cfg.AttesterBudgetSeconds)*time.Second))
 }
 
 // StartWithAttester starts with given attester implementation.
@@ -27,29 +66,94 @@ func StartWithAttester(cfg service.Config, attester interfaces.Attester) {
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 	logrus.SetLevel(cfg.LogLevel)
 
-	ethClient, err := ethclient.DialContext(ctx, cfg.TargetRPCURL)
-	if err != nil {
-		logrus.WithError(err).Panic("failed to dial target rpc")
+	if err := cfg.Validate(); err != nil {
+		logrus.WithError(err).Panic("invalid config")
 	}
-	chainID, err := ethClient.ChainID(ctx)
+
+	upstreams, err := clients.NewUpstreamPool(
+		ctx, append([]string{cfg.TargetRPCURL}, cfg.TargetRPCURLs...), cfg.TargetRPCWeights,
+		cfg.UpstreamMaxBlockLag, time.Duration(cfg.UpstreamMaxLatencyMillis)*time.Millisecond,
+	)
 	if err != nil {
-		logrus.WithError(err).Panic("failed to get chain id")
+		logrus.WithError(err).Panic("failed to create upstream pool")
 	}
-	rpcClient := ethClient.Client()
-
-	wrappedClient := clients.NewEthClient(ethClient)
+	go upstreams.Run(ctx, time.Duration(cfg.UpstreamHealthCheckSeconds)*time.Second)
+	chainID := upstreams.ChainID()
 
-	var bundler interfaces.Bundler
+	var (
+		bundler        interfaces.Bundler
+		bundleStore    interfaces.BundleStore
+		pendingBundles []*interfaces.PendingBundle
+	)
 	if len(cfg.BuilderAPIURL) > 0 {
 		bundler, err = clients.NewBuilderClient(ctx, cfg.BuilderAPIURL)
 		if err != nil {
 			logrus.WithError(err).Panic("failed to create new builder client")
 		}
 	} else {
-		bundler = clients.NewTxSender(wrappedClient, cfg.TxRetryTimes, cfg.TxRetryIntervalSeconds)
+		if len(cfg.BundleStorePath) > 0 {
+			boltStore, err := store.NewBundleStore(cfg.BundleStorePath)
+			if err != nil {
+				logrus.WithError(err).Panic("failed to create bundle store")
+			}
+			defer boltStore.Close()
+			bundleStore = boltStore
+			pendingBundles = clients.LoadPendingBundles(
+				bundleStore, time.Duration(cfg.PendingBundleMaxAgeSeconds)*time.Second,
+			)
+		}
+		watcher := clients.NewBlockWatcher(upstreams, cfg.TargetWSURL, time.Duration(cfg.TxBlockPollMillis)*time.Millisecond)
+		go watcher.Run(ctx)
+		txSender, err := clients.NewTxSender(
+			ctx, upstreams, bundleStore, watcher,
+			cfg.TxRetryTimes, cfg.TxRetryIntervalSeconds, cfg.TxRebroadcast, cfg.TxBundleAbortOn,
+		)
+		if err != nil {
+			logrus.WithError(err).Panic("failed to create tx sender")
+		}
+		bundler = txSender
+	}
+
+	policy, err := service.LoadRoutingPolicy(cfg.RoutingPolicyFile)
+	if err != nil {
+		logrus.WithError(err).Panic("failed to load routing policy")
+	}
+
+	keyStore, err := service.NewKeyStore(cfg.APIKeysFile, cfg.APIKey)
+	if err != nil {
+		logrus.WithError(err).Panic("failed to load api keys")
+	}
+	go keyStore.Watch(ctx, time.Duration(cfg.APIKeysReloadSeconds)*time.Second)
+
+	rateLimiter, err := service.NewRateLimiter(map[service.Route]service.RateLimit{
+		service.RouteWrapped:    {RPS: cfg.RateLimitWrappedRPS, Burst: cfg.RateLimitWrappedBurst},
+		service.RouteProxied:    {RPS: cfg.RateLimitProxiedRPS, Burst: cfg.RateLimitProxiedBurst},
+		service.RouteAuthorized: {RPS: cfg.RateLimitAuthorizedRPS, Burst: cfg.RateLimitAuthorizedBurst},
+	}, cfg.TrustedProxies)
+	if err != nil {
+		logrus.WithError(err).Panic("failed to create rate limiter")
+	}
+	go rateLimiter.Run(ctx)
+
+	var cache *service.ResponseCache
+	if cfg.CacheEnabled {
+		cache = service.NewResponseCache(cfg.CacheMaxEntries, time.Duration(cfg.CacheTTLMillis)*time.Millisecond)
+		prometheus.MustRegister(cache)
+	}
+
+	tracker := service.NewTxTracker(cfg.TxStatusMaxEntries, time.Duration(cfg.TxStatusRetentionMinutes)*time.Minute)
+
+	// The builder accepts the bundles right away so only the tx sender needs a queue.
+	var queue *service.SubmissionQueue
+	if cfg.AsyncSubmission && len(cfg.BuilderAPIURL) == 0 {
+		queue = service.NewSubmissionQueue(
+			bundler, bundleStore, tracker, cfg.SubmissionWorkers, cfg.SubmissionQueueSize,
+		)
+		go queue.Run(ctx)
 	}
+	go service.ResumeBundles(ctx, bundler, queue, pendingBundles)
 
-	srv := service.NewWrapperService(chainID, rpcClient, wrappedClient, bundler, attester)
+	srv, err := service.NewWrapperService(cfg, chainID, upstreams, upstreams, bundler, attester, tracker, queue)
 	if err != nil {
 		logrus.WithError(err).Panic("failed to create service")
 	}
@@ -62,10 +166,58 @@ func StartWithAttester(cfg service.Config, attester interfaces.Attester) {
 		AllowCredentials: true,
 	})
 
+	httpProxy := service.NewProxy(srv, upstreams, policy, keyStore, rateLimiter, cache)
+
+	if cfg.WSPort > 0 {
+		go func() {
+			// Only the handshake is limited as the connections are long-lived.
+			err := utils.ListenAndServe(ctx, &http.Server{
+				Handler:           service.NewWebSocketProxy(httpProxy, cfg.TargetWSURL),
+				Addr:              fmt.Sprintf("0.0.0.0:%d", cfg.WSPort),
+				BaseContext:       func(net.Listener) context.Context { return ctx },
+				ReadHeaderTimeout: 15 * time.Second,
+			}, fmt.Sprintf("started forta json-rpc websocket proxy for chain %d", chainID.Uint64()))
+			if err != nil {
+				logrus.WithError(err).Error("websocket server returned error")
+			}
+		}()
+	}
+
+	// Check the dependencies which support health checks.
+	dependencies := map[string]interfaces.HealthChecker{"target": upstreams}
+	if checker, ok := attester.(interfaces.HealthChecker); ok {
+		dependencies["attester"] = checker
+	}
+	if checker, ok := bundler.(interfaces.HealthChecker); ok && len(cfg.BuilderAPIURL) > 0 {
+		dependencies["builder"] = checker
+	}
+	healthHandler := service.NewHealthHandler(dependencies)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", c.Handler(httpProxy))
+	mux.HandleFunc("/healthz", healthHandler.ServeLiveness)
+	mux.HandleFunc("/readyz", healthHandler.ServeReadiness)
+
+	// The internal endpoints are not authenticated so they are never public.
+	if cfg.MetricsPort > 0 {
+		internalMux := http.NewServeMux()
+		internalMux.Handle("/metrics", promhttp.Handler())
+		internalMux.Handle("GET /transactions/{hash}", service.NewTxStatusHandler(tracker, upstreams))
+		go func() {
+			err := utils.ListenAndServe(ctx, &http.Server{
+				Handler: internalMux,
+				Addr:    fmt.Sprintf("0.0.0.0:%d", cfg.MetricsPort),
+			}, "started metrics server")
+			if err != nil {
+				logrus.WithError(err).Error("metrics server returned error")
+			}
+		}()
+	}
+
 	err = utils.ListenAndServe(ctx, &http.Server{
-		Handler:      c.Handler(service.NewProxy(srv, cfg.TargetRPCURL, cfg.APIKey)),
+		Handler:      mux,
 		Addr:         fmt.Sprintf("0.0.0.0:%d", cfg.Port),
-		WriteTimeout: 15 * time.Second,
+		WriteTimeout: serverWriteTimeout,
 		ReadTimeout:  15 * time.Second,
 	}, fmt.Sprintf("started forta json-rpc proxy for chain %d", chainID.Uint64()))
 	if err != nil {
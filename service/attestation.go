@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/forta-network/forta-json-rpc-proxy/interfaces"
+	"github.com/sirupsen/logrus"
+)
+
+// attestationVerifier checks the attestation transactions before they are bundled
+// with the user transactions, in case the attester is compromised or misconfigured.
+type attestationVerifier struct {
+	chainID           *big.Int
+	signer            types.Signer
+	trustedAttesters  map[common.Address]struct{}
+	securityValidator *common.Address
+	maxGas            uint64
+	maxFeePerGas      *big.Int
+}
+
+func newAttestationVerifier(cfg Config, chainID *big.Int) (*attestationVerifier, error) {
+	av := &attestationVerifier{
+		chainID:          chainID,
+		signer:           types.LatestSignerForChainID(chainID),
+		trustedAttesters: make(map[common.Address]struct{}),
+		maxGas:           cfg.AttestationMaxGas,
+	}
+	for _, attester := range cfg.TrustedAttesterAddresses {
+		attester = strings.TrimSpace(attester)
+		if !common.IsHexAddress(attester) {
+			return nil, fmt.Errorf("invalid trusted attester address %q", attester)
+		}
+		av.trustedAttesters[common.HexToAddress(attester)] = struct{}{}
+	}
+	if len(cfg.SecurityValidatorAddress) > 0 {
+		if !common.IsHexAddress(cfg.SecurityValidatorAddress) {
+			return nil, fmt.Errorf("invalid security validator address %q", cfg.SecurityValidatorAddress)
+		}
+		validator := common.HexToAddress(cfg.SecurityValidatorAddress)
+		av.securityValidator = &validator
+	}
+	if cfg.AttestationMaxFeePerGasGwei > 0 {
+		av.maxFeePerGas = new(big.Int).Mul(new(big.Int).SetUint64(cfg.AttestationMaxFeePerGasGwei), big.NewInt(params.GWei))
+	}
+	// The attestations cannot be trusted without these checks.
+	if len(av.trustedAttesters) == 0 || av.securityValidator == nil {
+		if !cfg.AttestationUnsafeSkipChecks {
+			return nil, errors.New("trusted attester addresses and security validator address are required")
+		}
+		logrus.Warn("attestation checks are skipped - not checking the missing attestation signers or destinations")
+	}
+	return av, nil
+}
+
+// verify decodes the attestation transaction and checks it against the configured
+// values and the chain state.
+func (av *attestationVerifier) verify(ctx context.Context, ethClient interfaces.EthClient, attestTx []byte) (*types.Transaction, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(attestTx); err != nil {
+		return nil, fmt.Errorf("failed to decode: %v", err)
+	}
+	if tx.ChainId().Cmp(av.chainID) != 0 {
+		return nil, fmt.Errorf("unexpected chain id %s", tx.ChainId())
+	}
+	signer, err := av.signer.Sender(tx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recover signer: %v", err)
+	}
+	if _, ok := av.trustedAttesters[signer]; !ok && len(av.trustedAttesters) > 0 {
+		return nil, fmt.Errorf("untrusted signer %s", signer.Hex())
+	}
+	if tx.To() == nil {
+		return nil, errors.New("contract deployment")
+	}
+	if av.securityValidator != nil && *tx.To() != *av.securityValidator {
+		return nil, fmt.Errorf("unexpected destination %s", tx.To().Hex())
+	}
+
+	if tx.Gas() == 0 || (av.maxGas > 0 && tx.Gas() > av.maxGas) {
+		return nil, fmt.Errorf("unexpected gas limit %d", tx.Gas())
+	}
+	if tx.GasFeeCap().Sign() <= 0 {
+		return nil, errors.New("zero fee")
+	}
+	if tx.GasTipCap().Cmp(tx.GasFeeCap()) > 0 {
+		return nil, fmt.Errorf("tip %s is higher than fee cap %s", tx.GasTipCap(), tx.GasFeeCap())
+	}
+	if av.maxFeePerGas != nil && tx.GasFeeCap().Cmp(av.maxFeePerGas) > 0 {
+		return nil, fmt.Errorf("fee cap %s is too high", tx.GasFeeCap())
+	}
+	header, err := ethClient.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest header: %v", err)
+	}
+	if header.BaseFee != nil && tx.GasFeeCap().Cmp(header.BaseFee) < 0 {
+		return nil, fmt.Errorf("fee cap %s is lower than base fee %s", tx.GasFeeCap(), header.BaseFee)
+	}
+
+	nonce, err := ethClient.NonceAt(ctx, signer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signer nonce: %v", err)
+	}
+	if tx.Nonce() < nonce {
+		return nil, fmt.Errorf("nonce %d is already used", tx.Nonce())
+	}
+	return tx, nil
+}
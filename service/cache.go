@@ -0,0 +1,172 @@
+package service
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	cacheHitsDesc = prometheus.NewDesc(
+		"forta_json_rpc_proxy_cache_hits_total", "Number of cache hits.", []string{"method"}, nil,
+	)
+	cacheMissesDesc = prometheus.NewDesc(
+		"forta_json_rpc_proxy_cache_misses_total", "Number of cache misses.", []string{"method"}, nil,
+	)
+)
+
+// CacheMode tells how the responses of a method are cached.
+type CacheMode string
+
+// Cache modes
+const (
+	// CacheNone disables caching.
+	CacheNone CacheMode = ""
+	// CachePermanent caches the responses which never change.
+	CachePermanent CacheMode = "permanent"
+	// CacheTTL caches the responses for a short duration, like a block time.
+	CacheTTL CacheMode = "ttl"
+)
+
+// CacheStats contains the cache hits and misses of a method.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+type cacheEntry struct {
+	key       string
+	result    json.RawMessage
+	expiresAt time.Time
+}
+
+// ResponseCache is an in-memory LRU cache of the JSON-RPC results.
+type ResponseCache struct {
+	maxEntries int
+	defaultTTL time.Duration
+
+	mu      sync.Mutex
+	ll      *list.List
+	entries map[string]*list.Element
+	stats   map[string]*CacheStats
+}
+
+// NewResponseCache creates a new response cache which holds up to the max number of
+// entries. Zero max entries makes the cache unbounded. The default TTL is used for
+// the methods which do not specify a TTL.
+func NewResponseCache(maxEntries int, defaultTTL time.Duration) *ResponseCache {
+	return &ResponseCache{
+		maxEntries: maxEntries,
+		defaultTTL: defaultTTL,
+		ll:         list.New(),
+		entries:    make(map[string]*list.Element),
+		stats:      make(map[string]*CacheStats),
+	}
+}
+
+// cacheKey makes a key from the method and the params.
+func cacheKey(msg *jsonrpcMessage) string {
+	var params bytes.Buffer
+	if err := json.Compact(&params, msg.Params); err != nil {
+		params.Write(msg.Params)
+	}
+	return msg.Method + ":" + params.String()
+}
+
+// get finds an unexpired result.
+func (c *ResponseCache) get(msg *jsonrpcMessage) (json.RawMessage, bool) {
+	key := cacheKey(msg)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.statsOf(msg.Method)
+	el, ok := c.entries[key]
+	if ok {
+		entry := el.Value.(*cacheEntry)
+		if entry.expiresAt.IsZero() || time.Now().Before(entry.expiresAt) {
+			c.ll.MoveToFront(el)
+			stats.Hits++
+			return entry.result, true
+		}
+		c.remove(el)
+	}
+	stats.Misses++
+	return nil, false
+}
+
+// put stores the result from a response if the response is successful and the
+// result is not null.
+func (c *ResponseCache) put(msg *jsonrpcMessage, policy *MethodPolicy, resp []byte) {
+	var respBody struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(resp, &respBody); err != nil {
+		return
+	}
+	if respBody.Error != nil || len(respBody.Result) == 0 || bytes.Equal(respBody.Result, jsonNull) {
+		return
+	}
+
+	entry := &cacheEntry{key: cacheKey(msg), result: respBody.Result}
+	if policy.Cache == CacheTTL {
+		ttl := c.defaultTTL
+		if policy.CacheTTLMillis > 0 {
+			ttl = time.Duration(policy.CacheTTLMillis) * time.Millisecond
+		}
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[entry.key]; ok {
+		c.remove(el)
+	}
+	c.entries[entry.key] = c.ll.PushFront(entry)
+	if c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *ResponseCache) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+func (c *ResponseCache) statsOf(method string) *CacheStats {
+	stats, ok := c.stats[method]
+	if !ok {
+		stats = &CacheStats{}
+		c.stats[method] = stats
+	}
+	return stats
+}
+
+// Stats returns the cache hits and misses per method.
+func (c *ResponseCache) Stats() map[string]CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make(map[string]CacheStats, len(c.stats))
+	for method, methodStats := range c.stats {
+		stats[method] = *methodStats
+	}
+	return stats
+}
+
+// Describe implements prometheus.Collector.
+func (c *ResponseCache) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheHitsDesc
+	ch <- cacheMissesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *ResponseCache) Collect(ch chan<- prometheus.Metric) {
+	for method, stats := range c.Stats() {
+		ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(stats.Hits), method)
+		ch <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(stats.Misses), method)
+	}
+}
@@ -1,18 +1,88 @@
 package service
 
 import (
+	"fmt"
+
 	"github.com/sirupsen/logrus"
 )
 
 // Config is the service config.
 type Config struct {
-	LogLevel               logrus.Level `default:"info" envconfig:"LOG_LEVEL"`
-	Port                   int          `default:"8545" envconfig:"PORT"`
-	TargetRPCURL           string       `required:"true" envconfig:"TARGET_RPC_URL"`
-	AttesterAPIURL         string       `required:"true" envconfig:"ATTESTER_API_URL"`
-	AttesterAuthToken      string       `required:"true" envconfig:"ATTESTER_AUTH_TOKEN"`
-	BuilderAPIURL          string       `envconfig:"BUILDER_API_URL"`
-	TxRetryTimes           int          `default:"10" envconfig:"TX_RETRY_TIMES"`
-	TxRetryIntervalSeconds int          `default:"2" envconfig:"TX_RETRY_INTERVAL_SECONDS"`
-	APIKey                 string       `envconfig:"API_KEY"`
+	LogLevel                       logrus.Level      `default:"info" envconfig:"LOG_LEVEL"`
+	Port                           int               `default:"8545" envconfig:"PORT"`
+	WSPort                         int               `envconfig:"WS_PORT"`
+	MetricsPort                    int               `envconfig:"METRICS_PORT"`
+	TargetRPCURL                   string            `required:"true" envconfig:"TARGET_RPC_URL"`
+	TargetRPCURLs                  []string          `envconfig:"TARGET_RPC_URLS"`
+	TargetRPCWeights               []int             `envconfig:"TARGET_RPC_WEIGHTS"`
+	TargetWSURL                    string            `envconfig:"TARGET_WS_URL"`
+	UpstreamHealthCheckSeconds     int               `default:"10" envconfig:"UPSTREAM_HEALTH_CHECK_SECONDS"`
+	UpstreamMaxBlockLag            uint64            `default:"5" envconfig:"UPSTREAM_MAX_BLOCK_LAG"`
+	UpstreamMaxLatencyMillis       int               `default:"2000" envconfig:"UPSTREAM_MAX_LATENCY_MILLIS"`
+	AttesterAPIURL                 string            `envconfig:"ATTESTER_API_URL"`
+	AttesterAuthToken              string            `envconfig:"ATTESTER_AUTH_TOKEN"`
+	AttestersFile                  string            `envconfig:"ATTESTERS_FILE"`
+	AttesterTimeoutSeconds         int               `default:"4" envconfig:"ATTESTER_TIMEOUT_SECONDS"`
+	AttesterBudgetSeconds          int               `default:"10" envconfig:"ATTESTER_BUDGET_SECONDS"`
+	AttesterRetries                int               `default:"2" envconfig:"ATTESTER_RETRIES"`
+	AttesterRetryBackoffMillis     int               `default:"200" envconfig:"ATTESTER_RETRY_BACKOFF_MILLIS"`
+	AttesterBreakerThreshold       int               `default:"5" envconfig:"ATTESTER_BREAKER_THRESHOLD"`
+	AttesterBreakerCooldownSeconds int               `default:"30" envconfig:"ATTESTER_BREAKER_COOLDOWN_SECONDS"`
+	AttesterFallback               string            `default:"reject" envconfig:"ATTESTER_FALLBACK"`
+	TrustedAttesterAddresses       []string          `envconfig:"TRUSTED_ATTESTER_ADDRESSES"`
+	SecurityValidatorAddress       string            `envconfig:"SECURITY_VALIDATOR_ADDRESS"`
+	AttestationUnsafeSkipChecks    bool              `envconfig:"ATTESTATION_UNSAFE_SKIP_CHECKS"`
+	AttestationMaxGas              uint64            `default:"1000000" envconfig:"ATTESTATION_MAX_GAS"`
+	AttestationMaxFeePerGasGwei    uint64            `envconfig:"ATTESTATION_MAX_FEE_PER_GAS_GWEI"`
+	BuilderAPIURL                  string            `envconfig:"BUILDER_API_URL"`
+	GasBumpPolicy                  string            `default:"fixed" envconfig:"GAS_BUMP_POLICY"`
+	GasBumpFixed                   uint64            `default:"50000" envconfig:"GAS_BUMP_FIXED"`
+	GasBumpPercent                 uint64            `default:"20" envconfig:"GAS_BUMP_PERCENT"`
+	GasBumpContracts               map[string]uint64 `envconfig:"GAS_BUMP_CONTRACTS"`
+	BypassOverridesFile            string            `envconfig:"BYPASS_OVERRIDES_FILE"`
+	AsyncSubmission                bool              `envconfig:"ASYNC_SUBMISSION"`
+	SubmissionWorkers              int               `default:"8" envconfig:"SUBMISSION_WORKERS"`
+	SubmissionQueueSize            int               `default:"100" envconfig:"SUBMISSION_QUEUE_SIZE"`
+	TxStatusMaxEntries             int               `default:"10000" envconfig:"TX_STATUS_MAX_ENTRIES"`
+	TxStatusRetentionMinutes       int               `default:"1440" envconfig:"TX_STATUS_RETENTION_MINUTES"`
+	BundleStorePath                string            `envconfig:"BUNDLE_STORE_PATH"`
+	PendingBundleMaxAgeSeconds     int               `default:"300" envconfig:"PENDING_BUNDLE_MAX_AGE_SECONDS"`
+	TxRetryTimes                   int               `default:"10" envconfig:"TX_RETRY_TIMES"`
+	TxBundleAbortOn                []string          `default:"send,unconfirmed,reverted" envconfig:"TX_BUNDLE_ABORT_ON"`
+	TxBlockPollMillis              int               `default:"1000" envconfig:"TX_BLOCK_POLL_MILLIS"`
+	TxRebroadcast                  bool              `envconfig:"TX_REBROADCAST"`
+	TxRetryIntervalSeconds         int               `default:"2" envconfig:"TX_RETRY_INTERVAL_SECONDS"`
+	APIKey                         string            `envconfig:"API_KEY"`
+	APIKeysFile                    string            `envconfig:"API_KEYS_FILE"`
+	APIKeysReloadSeconds           int               `default:"30" envconfig:"API_KEYS_RELOAD_SECONDS"`
+	RoutingPolicyFile              string            `envconfig:"ROUTING_POLICY_FILE"`
+	RateLimitWrappedRPS            float64           `envconfig:"RATE_LIMIT_WRAPPED_RPS"`
+	RateLimitWrappedBurst          int               `default:"1" envconfig:"RATE_LIMIT_WRAPPED_BURST"`
+	RateLimitProxiedRPS            float64           `envconfig:"RATE_LIMIT_PROXIED_RPS"`
+	RateLimitProxiedBurst          int               `default:"1" envconfig:"RATE_LIMIT_PROXIED_BURST"`
+	RateLimitAuthorizedRPS         float64           `envconfig:"RATE_LIMIT_AUTHORIZED_RPS"`
+	RateLimitAuthorizedBurst       int               `default:"1" envconfig:"RATE_LIMIT_AUTHORIZED_BURST"`
+	TrustedProxies                 []string          `envconfig:"TRUSTED_PROXIES"`
+	CacheEnabled                   bool              `default:"true" envconfig:"CACHE_ENABLED"`
+	CacheMaxEntries                int               `default:"10000" envconfig:"CACHE_MAX_ENTRIES"`
+	CacheTTLMillis                 int               `default:"2000" envconfig:"CACHE_TTL_MILLIS"`
+}
+
+// Validate checks the config values which cannot be used as they are.
+func (cfg Config) Validate() error {
+	// The intervals are used for tickers.
+	intervals := []struct {
+		name  string
+		value int
+	}{
+		{"TX_BLOCK_POLL_MILLIS", cfg.TxBlockPollMillis},
+		{"UPSTREAM_HEALTH_CHECK_SECONDS", cfg.UpstreamHealthCheckSeconds},
+		{"API_KEYS_RELOAD_SECONDS", cfg.APIKeysReloadSeconds},
+	}
+	for _, interval := range intervals {
+		if interval.value <= 0 {
+			return fmt.Errorf("%s should be positive", interval.name)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/sirupsen/logrus"
+)
+
+// debugService wraps a few JSON-RPC methods in the debug namespace.
+type debugService struct {
+	s *wrapperService
+}
+
+func (ds *debugService) TraceCall(ctx context.Context, txArgs TransactionArgs, blockNrOrHash rpc.BlockNumberOrHash, config *TraceCallConfig) (result json.RawMessage, err error) {
+	if config == nil {
+		config = &TraceCallConfig{}
+	}
+	config.StateOverrides = ds.s.bypass.AddFortaFirewallStateOverride(config.StateOverrides)
+	err = ds.s.rpcClient.CallContext(ctx, &result, "debug_traceCall", txArgs, blockNrOrHash, config)
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"txArgs":        txArgs,
+			"blockNrOrHash": blockNrOrHash,
+			"config":        config,
+		}).Info("debug_traceCall failed")
+	}
+	return
+}
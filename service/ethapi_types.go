@@ -1,6 +1,8 @@
 package service
 
 import (
+	"encoding/json"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -33,14 +35,29 @@ type TransactionArgs struct {
 
 // BlockOverrides is a set of header fields to override.
 type BlockOverrides struct {
-	Number      *hexutil.Big
-	Difficulty  *hexutil.Big
-	Time        *hexutil.Uint64
-	GasLimit    *hexutil.Uint64
-	Coinbase    *common.Address
-	Random      *common.Hash
-	BaseFee     *hexutil.Big
-	BlobBaseFee *hexutil.Big
+	Number        *hexutil.Big    `json:"number,omitempty"`
+	Difficulty    *hexutil.Big    `json:"difficulty,omitempty"` // No-op if we're simulating post-merge calls.
+	Time          *hexutil.Uint64 `json:"time,omitempty"`
+	GasLimit      *hexutil.Uint64 `json:"gasLimit,omitempty"`
+	FeeRecipient  *common.Address `json:"feeRecipient,omitempty"`
+	PrevRandao    *common.Hash    `json:"prevRandao,omitempty"`
+	BaseFeePerGas *hexutil.Big    `json:"baseFeePerGas,omitempty"`
+	BlobBaseFee   *hexutil.Big    `json:"blobBaseFee,omitempty"`
+}
+
+// SimBlock is a batch of calls to be simulated sequentially.
+type SimBlock struct {
+	BlockOverrides *BlockOverrides   `json:"blockOverrides,omitempty"`
+	StateOverrides *StateOverride    `json:"stateOverrides,omitempty"`
+	Calls          []TransactionArgs `json:"calls"`
+}
+
+// SimOpts are the inputs to eth_simulateV1.
+type SimOpts struct {
+	BlockStateCalls        []SimBlock `json:"blockStateCalls"`
+	TraceTransfers         bool       `json:"traceTransfers"`
+	Validation             bool       `json:"validation"`
+	ReturnFullTransactions bool       `json:"returnFullTransactions"`
 }
 
 // StateOverride is the collection of overridden accounts.
@@ -59,3 +76,26 @@ type OverrideAccount struct {
 	State     *map[common.Hash]common.Hash `json:"state"`
 	StateDiff *map[common.Hash]common.Hash `json:"stateDiff"`
 }
+
+// Below types are from go-ethereum eth/tracers
+
+// TraceCallConfig is the config for debug_traceCall. It contains the fields of
+// the struct logger config and the tracer config.
+type TraceCallConfig struct {
+	EnableMemory     bool            `json:"enableMemory,omitempty"`
+	DisableStack     bool            `json:"disableStack,omitempty"`
+	DisableStorage   bool            `json:"disableStorage,omitempty"`
+	EnableReturnData bool            `json:"enableReturnData,omitempty"`
+	Debug            bool            `json:"debug,omitempty"`
+	Limit            int             `json:"limit,omitempty"`
+	Overrides        json.RawMessage `json:"overrides,omitempty"`
+
+	Tracer       *string         `json:"tracer,omitempty"`
+	Timeout      *string         `json:"timeout,omitempty"`
+	Reexec       *uint64         `json:"reexec,omitempty"`
+	TracerConfig json.RawMessage `json:"tracerConfig,omitempty"`
+
+	StateOverrides *StateOverride  `json:"stateOverrides,omitempty"`
+	BlockOverrides *BlockOverrides `json:"blockOverrides,omitempty"`
+	TxIndex        *hexutil.Uint   `json:"txIndex,omitempty"`
+}
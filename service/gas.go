@@ -0,0 +1,59 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Gas bump policies
+const (
+	GasBumpFixed   = "fixed"
+	GasBumpPercent = "percent"
+)
+
+// gasBumpPolicy adds extra gas on top of the estimations to cover the cost of
+// the checkpoint execution, which is bypassed during the estimation.
+type gasBumpPolicy struct {
+	policy    string
+	fixed     uint64
+	percent   uint64
+	contracts map[common.Address]uint64
+}
+
+func newGasBumpPolicy(cfg Config) (*gasBumpPolicy, error) {
+	switch cfg.GasBumpPolicy {
+	case GasBumpFixed, GasBumpPercent:
+	default:
+		return nil, fmt.Errorf("unknown gas bump policy %q", cfg.GasBumpPolicy)
+	}
+	gb := &gasBumpPolicy{
+		policy:    cfg.GasBumpPolicy,
+		fixed:     cfg.GasBumpFixed,
+		percent:   cfg.GasBumpPercent,
+		contracts: make(map[common.Address]uint64),
+	}
+	for contract, gas := range cfg.GasBumpContracts {
+		contract = strings.TrimSpace(contract)
+		if !common.IsHexAddress(contract) {
+			return nil, fmt.Errorf("invalid gas bump contract address %q", contract)
+		}
+		gb.contracts[common.HexToAddress(contract)] = gas
+	}
+	return gb, nil
+}
+
+// bump adds the configured extra gas to the estimation. The destination contract
+// values take precedence over the policy.
+func (gb *gasBumpPolicy) bump(to *common.Address, gas uint64) uint64 {
+	if to != nil {
+		if extra, ok := gb.contracts[*to]; ok {
+			return gas + extra
+		}
+	}
+	if gb.policy == GasBumpPercent {
+		return gas + gas*gb.percent/100
+	}
+	return gas + gb.fixed
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/forta-network/forta-json-rpc-proxy/interfaces"
+	"github.com/sirupsen/logrus"
+)
+
+const healthCheckTimeout = 5 * time.Second
+
+// Health statuses
+const (
+	HealthStatusOK          = "ok"
+	HealthStatusUnavailable = "unavailable"
+)
+
+type dependencyHealth struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status       string                       `json:"status"`
+	Dependencies map[string]*dependencyHealth `json:"dependencies,omitempty"`
+}
+
+// HealthHandler serves the liveness and the readiness endpoints.
+type HealthHandler struct {
+	dependencies map[string]interfaces.HealthChecker
+}
+
+// NewHealthHandler creates a new health handler which checks the given dependencies
+// for readiness.
+func NewHealthHandler(dependencies map[string]interfaces.HealthChecker) *HealthHandler {
+	return &HealthHandler{dependencies: dependencies}
+}
+
+// ServeLiveness tells that the process is alive.
+func (hh *HealthHandler) ServeLiveness(w http.ResponseWriter, r *http.Request) {
+	writeHealthResponse(w, &healthResponse{Status: HealthStatusOK})
+}
+
+// ServeReadiness checks all dependencies and responds with the status of each.
+func (hh *HealthHandler) ServeReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	resp := &healthResponse{
+		Status:       HealthStatusOK,
+		Dependencies: make(map[string]*dependencyHealth),
+	}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for name, dependency := range hh.dependencies {
+		wg.Add(1)
+		go func(name string, dependency interfaces.HealthChecker) {
+			defer wg.Done()
+			health := &dependencyHealth{Status: HealthStatusOK}
+			if err := dependency.CheckHealth(ctx); err != nil {
+				logrus.WithError(err).WithField("dependency", name).Warn("dependency is not healthy")
+				health.Status = HealthStatusUnavailable
+				health.Error = err.Error()
+			}
+			mu.Lock()
+			resp.Dependencies[name] = health
+			if health.Status != HealthStatusOK {
+				resp.Status = HealthStatusUnavailable
+			}
+			mu.Unlock()
+		}(name, dependency)
+	}
+	wg.Wait()
+
+	if resp.Status != HealthStatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	writeHealthResponse(w, resp)
+}
+
+func writeHealthResponse(w http.ResponseWriter, resp *healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -2,6 +2,10 @@ package service
 
 import (
 	"encoding/json"
+
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/forta-network/forta-json-rpc-proxy/interfaces"
+	"github.com/sirupsen/logrus"
 )
 
 var (
@@ -9,6 +13,18 @@ var (
 	jsonRpcVersion = "2.0"
 )
 
+type jsonrpcMessage struct {
+	Version string          `json:"jsonrpc,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// isNotification tells if the message does not expect a response.
+func (msg *jsonrpcMessage) isNotification() bool {
+	return msg.ID == nil && len(msg.Method) > 0
+}
+
 type jsonrpcErrorMessage struct {
 	Version string          `json:"jsonrpc,omitempty"`
 	ID      json.RawMessage `json:"id,omitempty"`
@@ -16,41 +32,113 @@ type jsonrpcErrorMessage struct {
 }
 
 type jsonError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
 }
 
+// withData returns a copy of the error with the given data.
+func (err *jsonError) withData(data interface{}) *jsonError {
+	return &jsonError{Code: err.Code, Message: err.Message, Data: data}
+}
+
+// The codes below -32600 are the standard JSON-RPC 2.0 codes. The rest are from
+// the EIP-1474 range, except the unauthorized code which is specific to this proxy.
+const (
+	errCodeParse               = -32700
+	errCodeInvalidRequest      = -32600
+	errCodeMethodNotFound      = -32601
+	errCodeInvalidParams       = -32602
+	errCodeInternal            = -32603
+	errCodeUpstreamUnavailable = -32002
+	errCodeAttestationRejected = -32003
+	errCodeLimitExceeded       = -32005
+	errCodeUnauthorized        = -32010
+)
+
 var (
-	methodParseError    []byte
-	methodNotFoundError []byte
+	errParse               = &jsonError{Code: errCodeParse, Message: "failed to parse json-rpc request"}
+	errInvalidRequest      = &jsonError{Code: errCodeInvalidRequest, Message: "invalid json-rpc request"}
+	errBatchTooLarge       = &jsonError{Code: errCodeInvalidRequest, Message: "batch too large"}
+	errRequestTooLarge     = &jsonError{Code: errCodeInvalidRequest, Message: "request too large"}
+	errMethodNotFound      = &jsonError{Code: errCodeMethodNotFound, Message: "method not available"}
+	errInvalidParams       = &jsonError{Code: errCodeInvalidParams, Message: "invalid params"}
+	errInternal            = &jsonError{Code: errCodeInternal, Message: "internal error"}
+	errUpstreamUnavailable = &jsonError{Code: errCodeUpstreamUnavailable, Message: "upstream unavailable"}
+	errUnauthorized        = &jsonError{Code: errCodeUnauthorized, Message: "method requires authorization"}
+	errLimitExceeded       = &jsonError{Code: errCodeLimitExceeded, Message: "rate limit exceeded"}
 )
 
-func init() {
-	var err error
+// attestationRejectedError is returned from the wrapped methods when the attester
+// refuses to attest to a transaction, so that the wallets can tell a security block
+// apart from the other failures.
+type attestationRejectedError struct {
+	rejected *interfaces.AttestationRejectedError
+}
 
-	// The errors are from go-ethereum rpc error codes.
+type attestationRejectedData struct {
+	Reason       string   `json:"reason"`
+	AttesterCode int      `json:"attesterCode,omitempty"`
+	ReferenceID  string   `json:"referenceId,omitempty"`
+	RiskLabels   []string `json:"riskLabels,omitempty"`
+}
 
-	methodParseError, err = json.Marshal(&jsonrpcErrorMessage{
+var (
+	_ rpc.Error     = &attestationRejectedError{}
+	_ rpc.DataError = &attestationRejectedError{}
+)
+
+func (err *attestationRejectedError) Error() string {
+	return "attestation rejected: " + err.rejected.Message
+}
+
+// ErrorCode implements rpc.Error.
+func (err *attestationRejectedError) ErrorCode() int {
+	return errCodeAttestationRejected
+}
+
+// ErrorData implements rpc.DataError.
+func (err *attestationRejectedError) ErrorData() interface{} {
+	return &attestationRejectedData{
+		Reason:       err.rejected.Message,
+		AttesterCode: err.rejected.Code,
+		ReferenceID:  err.rejected.ReferenceID,
+		RiskLabels:   err.rejected.RiskLabels,
+	}
+}
+
+// errorResponse creates an error response for the request with given id. The null id
+// should be used when the id of the request could not be determined.
+func errorResponse(id json.RawMessage, jerr *jsonError) []byte {
+	if id == nil {
+		id = jsonNull
+	}
+	b, err := json.Marshal(&jsonrpcErrorMessage{
 		Version: jsonRpcVersion,
-		ID:      jsonNull,
-		Error: &jsonError{
-			Code:    -32600,
-			Message: "failed to parse json-rpc method",
-		},
+		ID:      id,
+		Error:   jerr,
 	})
 	if err != nil {
-		panic(err)
+		logrus.WithError(err).Error("failed to marshal json-rpc error")
+		return nil
 	}
+	return b
+}
 
-	methodNotFoundError, err = json.Marshal(&jsonrpcErrorMessage{
+// resultResponse creates a response for the request with given id and result.
+func resultResponse(id json.RawMessage, result json.RawMessage) []byte {
+	b, err := json.Marshal(&struct {
+		Version string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  json.RawMessage `json:"result"`
+	}{
 		Version: jsonRpcVersion,
-		ID:      jsonNull,
-		Error: &jsonError{
-			Code:    -32601,
-			Message: "method not available",
-		},
+		ID:      id,
+		Result:  result,
 	})
 	if err != nil {
-		panic(err)
+		logrus.WithError(err).Error("failed to marshal json-rpc result")
+		return nil
 	}
+	return b
 }
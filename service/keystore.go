@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// allMethods is the wildcard which allows an API key to access all methods.
+const allMethods = "*"
+
+// APIKey is an entry in the key store. The key itself is never stored and only
+// the hex-encoded SHA-256 hash of it is known.
+type APIKey struct {
+	Label     string     `json:"label"`
+	KeyHash   string     `json:"keyHash"`
+	Methods   []string   `json:"methods"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Enabled   *bool      `json:"enabled,omitempty"`
+
+	methods map[string]struct{}
+}
+
+// isActive tells if the key is enabled and not expired yet.
+func (key *APIKey) isActive(now time.Time) bool {
+	if key.Enabled != nil && !*key.Enabled {
+		return false
+	}
+	return key.ExpiresAt == nil || now.Before(*key.ExpiresAt)
+}
+
+// Allows tells if the key can be used for calling the method.
+func (key *APIKey) Allows(method string) bool {
+	if _, ok := key.methods[allMethods]; ok {
+		return true
+	}
+	_, ok := key.methods[method]
+	return ok
+}
+
+// HashAPIKey returns the hash of an API key as it is expected in the key store.
+func HashAPIKey(apiKey string) string {
+	h := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(h[:])
+}
+
+// KeyStore keeps the API keys which authorize the requests. The keys are read from
+// a JSON file and can be reloaded whenever the file changes.
+type KeyStore struct {
+	path      string
+	staticKey *APIKey
+
+	mu      sync.RWMutex
+	keys    map[string]*APIKey
+	modTime time.Time
+}
+
+// NewKeyStore creates a new key store from the keys file and the single API key.
+// The single API key is kept as an entry which allows all methods.
+func NewKeyStore(path, apiKey string) (*KeyStore, error) {
+	ks := &KeyStore{path: path, keys: make(map[string]*APIKey)}
+	if len(apiKey) > 0 {
+		ks.staticKey = &APIKey{
+			Label:   "default",
+			KeyHash: HashAPIKey(apiKey),
+			methods: map[string]struct{}{allMethods: {}},
+		}
+		ks.keys[ks.staticKey.KeyHash] = ks.staticKey
+	}
+	if len(path) == 0 {
+		return ks, nil
+	}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Configured tells if there are any API keys.
+func (ks *KeyStore) Configured() bool {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return len(ks.keys) > 0
+}
+
+// Reload reads the keys file and replaces the keys in the store.
+func (ks *KeyStore) Reload() error {
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat keys file: %v", err)
+	}
+	b, err := os.ReadFile(ks.path)
+	if err != nil {
+		return fmt.Errorf("failed to read keys file: %v", err)
+	}
+	var fileKeys []*APIKey
+	if err := json.Unmarshal(b, &fileKeys); err != nil {
+		return fmt.Errorf("failed to decode keys file: %v", err)
+	}
+
+	keys := make(map[string]*APIKey)
+	if ks.staticKey != nil {
+		keys[ks.staticKey.KeyHash] = ks.staticKey
+	}
+	for i, key := range fileKeys {
+		if err := key.init(); err != nil {
+			return fmt.Errorf("invalid key at index %d: %v", i, err)
+		}
+		if _, ok := keys[key.KeyHash]; ok {
+			return fmt.Errorf("duplicate key at index %d", i)
+		}
+		keys[key.KeyHash] = key
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.modTime = info.ModTime()
+	ks.mu.Unlock()
+	return nil
+}
+
+func (key *APIKey) init() error {
+	if len(key.Label) == 0 {
+		return errors.New("missing label")
+	}
+	key.KeyHash = strings.ToLower(strings.TrimPrefix(key.KeyHash, "0x"))
+	if b, err := hex.DecodeString(key.KeyHash); err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("%s: key hash is not a hex-encoded sha256 hash", key.Label)
+	}
+	if len(key.Methods) == 0 {
+		return fmt.Errorf("%s: no methods allowed", key.Label)
+	}
+	key.methods = make(map[string]struct{})
+	for _, method := range key.Methods {
+		key.methods[method] = struct{}{}
+	}
+	return nil
+}
+
+// Watch reloads the keys whenever the keys file is modified, until the context
+// is done.
+func (ks *KeyStore) Watch(ctx context.Context, interval time.Duration) {
+	if len(ks.path) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(ks.path)
+		if err != nil {
+			logrus.WithError(err).Warn("failed to stat keys file")
+			continue
+		}
+		ks.mu.RLock()
+		modified := !info.ModTime().Equal(ks.modTime)
+		ks.mu.RUnlock()
+		if !modified {
+			continue
+		}
+		if err := ks.Reload(); err != nil {
+			logrus.WithError(err).Error("failed to reload keys - keeping the previous keys")
+			continue
+		}
+		logrus.Info("reloaded api keys")
+	}
+}
+
+// Authenticate finds the active key from the authorization header value.
+func (ks *KeyStore) Authenticate(authHeaderVal string) *APIKey {
+	apiKey, ok := strings.CutPrefix(authHeaderVal, "Bearer ")
+	if !ok || len(apiKey) == 0 {
+		return nil
+	}
+	ks.mu.RLock()
+	key, ok := ks.keys[HashAPIKey(apiKey)]
+	ks.mu.RUnlock()
+	if !ok || !key.isActive(time.Now()) {
+		return nil
+	}
+	return key
+}
@@ -2,114 +2,348 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/forta-network/forta-json-rpc-proxy/interfaces"
+	"github.com/forta-network/forta-json-rpc-proxy/metrics"
 	"github.com/forta-network/forta-json-rpc-proxy/utils"
 	"github.com/sirupsen/logrus"
 )
 
-var wrappedMethods = map[string]interface{}{
-	"eth_sendRawTransaction": struct{}{},
-	"eth_call":               struct{}{},
-	"eth_estimateGas":        struct{}{},
-}
+// maxBatchSize is the maximum number of messages accepted in a batch request.
+// This is the same as the go-ethereum default.
+const maxBatchSize = 1000
 
-var proxiedMethods = map[string]interface{}{
-	"net_version":               struct{}{},
-	"eth_chainId":               struct{}{},
-	"eth_getBalance":            struct{}{},
-	"eth_getTransactionCount":   struct{}{},
-	"eth_getBlockByNumber":      struct{}{},
-	"eth_getBlockByHash":        struct{}{},
-	"eth_blockNumber":           struct{}{},
-	"eth_getCode":               struct{}{},
-	"eth_gasPrice":              struct{}{},
-	"eth_getTransactionReceipt": struct{}{},
-	"eth_feeHistory":            struct{}{},
-	"eth_maxPriorityFeePerGas":  struct{}{},
-}
+// batchConcurrency is the maximum number of messages of a batch handled at once.
+const batchConcurrency = 16
+
+// upstreamKey is the request context key for the selected upstream URL.
+type upstreamKey struct{}
 
 // Proxy intercepts and forwards JSON-RPC requests.
 type Proxy struct {
-	rpcServer        *rpc.Server
-	reverseProxy     *httputil.ReverseProxy
-	apiKeyConfigured bool
-	authHeaderVal    string
+	rpcServer    *rpc.Server
+	reverseProxy *httputil.ReverseProxy
+	upstreams    interfaces.UpstreamSelector
+	policy       *RoutingPolicy
+	keyStore     *KeyStore
+	rateLimiter  *RateLimiter
+	cache        *ResponseCache
 }
 
 // NewProxy creates a new proxy which can handle HTTP requests with the help of a registered
 // JSON-RPC service.
-func NewProxy(service *wrapperService, target, apiKey string) *Proxy {
+func NewProxy(
+	service *wrapperService, upstreams interfaces.UpstreamSelector, policy *RoutingPolicy,
+	keyStore *KeyStore, rateLimiter *RateLimiter, cache *ResponseCache,
+) *Proxy {
 	rpcServer := rpc.NewServer()
 	err := rpcServer.RegisterName("eth", service)
 	if err != nil {
 		logrus.WithError(err).Panic("failed to register rpc service to eth namespace")
 	}
-	targetURL, err := url.Parse(target)
+	err = rpcServer.RegisterName("debug", &debugService{s: service})
+	if err != nil {
+		logrus.WithError(err).Panic("failed to register rpc service to debug namespace")
+	}
+	err = rpcServer.RegisterName("forta", &fortaService{s: service})
 	if err != nil {
-		logrus.WithError(err).Panic("failed to parse target url for reverse proxy")
+		logrus.WithError(err).Panic("failed to register rpc service to forta namespace")
 	}
-	reverseProxy := httputil.NewSingleHostReverseProxy(targetURL)
+	reverseProxy := &httputil.ReverseProxy{}
 	reverseProxy.Transport = utils.DefaultHTTPTransport
 	reverseProxy.Director = func(r *http.Request) {
+		targetURL := r.Context().Value(upstreamKey{}).(*url.URL)
 		r.Host = targetURL.Host
 		r.URL = targetURL
 		r.Header.Del("Authorization") // strip proxy auth header
+		// Let the transport negotiate and decompress so that the responses
+		// can be reassembled in batches.
+		r.Header.Del("Accept-Encoding")
+	}
+	// Leave the error response to the handler.
+	reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		logrus.WithError(err).Warn("failed to proxy request")
+		w.WriteHeader(http.StatusBadGateway)
 	}
 	return &Proxy{
-		rpcServer:        rpcServer,
-		reverseProxy:     reverseProxy,
-		apiKeyConfigured: len(apiKey) > 0,
-		authHeaderVal:    fmt.Sprintf("Bearer %s", apiKey),
+		rpcServer:    rpcServer,
+		reverseProxy: reverseProxy,
+		upstreams:    upstreams,
+		policy:       policy,
+		keyStore:     keyStore,
+		rateLimiter:  rateLimiter,
+		cache:        cache,
 	}
 }
 
 // ServeHTTP implements http.Handler.
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	b, _ := io.ReadAll(r.Body)
-	r.Body = io.NopCloser(bytes.NewBuffer(b)) // replace request body
-	var reqBody struct {
-		Method string `json:"method"`
-	}
-	err := json.Unmarshal(b, &reqBody)
-	if err != nil || reqBody.Method == "" {
-		w.WriteHeader(200)
-		w.Write(methodParseError)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+
+	if isBatch(b) {
+		w.Write(p.serveBatch(r, b))
 		return
 	}
+	w.Write(p.serveMessage(r, b))
+}
 
-	w.Header().Set("Content-Type", "application/json")
+// serveBatch splits a batch request, handles the messages concurrently and reassembles
+// the responses into a batch response, in the order of the messages.
+func (p *Proxy) serveBatch(r *http.Request, b []byte) []byte {
+	var msgs []json.RawMessage
+	if err := json.Unmarshal(b, &msgs); err != nil {
+		return errorResponse(jsonNull, errParse)
+	}
+	if len(msgs) == 0 {
+		return errorResponse(jsonNull, errInvalidRequest)
+	}
+	if len(msgs) > maxBatchSize {
+		return errorResponse(jsonNull, errBatchTooLarge)
+	}
+
+	msgResps := make([][]byte, len(msgs))
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i, msg := range msgs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			msgResps[i] = p.serveMessage(r, msg)
+		}()
+	}
+	wg.Wait()
+
+	var resp bytes.Buffer
+	resp.WriteByte('[')
+	for _, msgResp := range msgResps {
+		// Notifications do not get a response.
+		if len(msgResp) == 0 {
+			continue
+		}
+		if resp.Len() > 1 {
+			resp.WriteByte(',')
+		}
+		resp.Write(msgResp)
+	}
+	// A batch of notifications should not get a response either.
+	if resp.Len() == 1 {
+		return nil
+	}
+	resp.WriteByte(']')
+	return resp.Bytes()
+}
+
+// admission is a message which passed the checks of the proxy.
+type admission struct {
+	msg    jsonrpcMessage
+	policy *MethodPolicy
+	key    *APIKey
+	logger *logrus.Entry
+}
+
+// admit parses the message and checks it against the routing policy, the API keys
+// and the rate limits. If the message is not admitted, the error response is returned
+// instead, which is empty for notifications.
+func (p *Proxy) admit(r *http.Request, b []byte) (*admission, []byte) {
+	if !json.Valid(b) {
+		return nil, errorResponse(jsonNull, errParse)
+	}
+	var msg jsonrpcMessage
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return nil, errorResponse(jsonNull, errInvalidRequest)
+	}
+	if msg.Method == "" {
+		return nil, errorResponse(msg.ID, errInvalidRequest)
+	}
+	if !hasValidParams(&msg) {
+		return nil, p.errorResponse(&msg, errInvalidParams)
+	}
+
+	policy := p.policy.Lookup(msg.Method)
+	if policy.MaxRequestBytes > 0 && len(b) > policy.MaxRequestBytes {
+		return nil, p.errorResponse(&msg, errRequestTooLarge)
+	}
+
+	logger := logrus.WithFields(logrus.Fields{
+		"method": msg.Method,
+		"route":  policy.Route,
+	})
+	key := p.keyStore.Authenticate(r.Header.Get("Authorization"))
+	if key != nil {
+		logger = logger.WithField("apiKey", key.Label)
+	}
+
+	switch {
+	// Disallow denied methods.
+	case policy.Route == RouteDenied:
+		return nil, p.errorResponse(&msg, errMethodNotFound)
+
+	// Some methods are available only to the power users.
+	case policy.requiresAuth() && !p.isAuthorized(key, msg.Method):
+		return nil, p.unauthorizedResponse(&msg, logger)
+	}
+
+	if delay := p.rateLimiter.Allow(r, policy, key); delay > 0 {
+		logger.Debug("rate limit exceeded")
+		return nil, p.errorResponse(&msg, errLimitExceeded.withData(map[string]interface{}{
+			"retryAfterSeconds": retryAfterSeconds(delay),
+		}))
+	}
+
+	return &admission{msg: msg, policy: policy, key: key, logger: logger}, nil
+}
+
+func (p *Proxy) isAuthorized(key *APIKey, method string) bool {
+	return key != nil && key.Allows(method)
+}
+
+// unauthorizedResponse hides the authorized methods if there are no API keys.
+func (p *Proxy) unauthorizedResponse(msg *jsonrpcMessage, logger *logrus.Entry) []byte {
+	if p.keyStore.Configured() {
+		logger.Debug("unauthorized request")
+		return p.errorResponse(msg, errUnauthorized)
+	}
+	return p.errorResponse(msg, errMethodNotFound)
+}
+
+// serveMessage handles a single JSON-RPC message and returns the response.
+func (p *Proxy) serveMessage(r *http.Request, b []byte) []byte {
+	adm, errResp := p.admit(r, b)
+	if adm == nil {
+		return errResp
+	}
+	return p.handle(r, b, adm)
+}
+
+// handle handles an admitted message according to the routing policy and returns
+// the response.
+func (p *Proxy) handle(r *http.Request, b []byte, adm *admission) []byte {
+	msg, policy, logger := &adm.msg, adm.policy, adm.logger
+
+	method, route := p.methodLabel(msg.Method), string(policy.Route)
+	metrics.Requests.WithLabelValues(method, route).Inc()
+	defer func(start time.Time) {
+		metrics.RequestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}(time.Now())
+
+	ctx := r.Context()
+	if timeout := policy.timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
 	// Handle wrapped methods by the handlers of the local service.
-	if _, ok := wrappedMethods[reqBody.Method]; ok {
-		logrus.WithField("method", reqBody.Method).Debug("received request for wrapped method")
-		p.rpcServer.ServeHTTP(w, r)
-		return
+	if policy.Route == RouteWrapped {
+		logger.Debug("received request for wrapped method")
+		rb := newResponseBuffer()
+		p.rpcServer.ServeHTTP(rb, newRequestWithBody(ctx, r, b))
+		resp := rb.Bytes()
+		// The local service is expected to always give back a JSON-RPC response.
+		if !msg.isNotification() && (len(resp) == 0 || !json.Valid(resp)) {
+			logger.WithField("statusCode", rb.statusCode).Error("received invalid response from local service")
+			return p.errorResponse(msg, errInternal)
+		}
+		return resp
 	}
 
-	// Handle proxied methods by proxying to the target URL.
-	if _, ok := proxiedMethods[reqBody.Method]; ok {
-		logrus.WithField("method", reqBody.Method).Debug("received request for proxied method")
-		p.reverseProxy.ServeHTTP(w, r)
-		return
+	// Handle proxied and authorized methods from the cache or by proxying to the upstreams.
+	logger.Debug("received request for proxied method")
+	cacheable := p.cache != nil && policy.Cache != CacheNone && !msg.isNotification()
+	if cacheable {
+		if result, ok := p.cache.get(msg); ok {
+			logger.Debug("serving from cache")
+			return resultResponse(msg.ID, result)
+		}
+	}
+	var statusCode int
+	for _, target := range p.upstreams.Candidates() {
+		metrics.UpstreamRequests.WithLabelValues(target.Host).Inc()
+		rb := newResponseBuffer()
+		p.reverseProxy.ServeHTTP(rb, newRequestWithBody(context.WithValue(ctx, upstreamKey{}, target), r, b))
+		resp := rb.Bytes()
+		if msg.isNotification() {
+			return resp
+		}
+		if len(resp) > 0 && json.Valid(resp) {
+			if cacheable {
+				p.cache.put(msg, policy, resp)
+			}
+			return resp
+		}
+		statusCode = rb.statusCode
+		logger.WithFields(logrus.Fields{
+			"upstream":   target.Host,
+			"statusCode": statusCode,
+		}).Warn("received invalid response from upstream")
+		metrics.UpstreamErrors.WithLabelValues(target.Host).Inc()
+		p.upstreams.MarkFailed(target)
+		if ctx.Err() != nil {
+			break
+		}
 	}
+	return p.errorResponse(msg, errUpstreamUnavailable.withData(map[string]interface{}{
+		"statusCode": statusCode,
+	}))
+}
 
-	// Allow all proxied methods for requests with an API key (power user).
-	// The wrapped methods are enabled for everyone and that's already handled
-	// as part of the first case above.
-	if p.apiKeyConfigured && r.Header.Get("Authorization") == p.authHeaderVal {
-		logrus.WithField("method", reqBody.Method).Debug("received request for authorized method")
-		p.reverseProxy.ServeHTTP(w, r)
-		return
+// newRequestWithBody creates a new request from the original request, with
+// a single message body.
+func newRequestWithBody(ctx context.Context, r *http.Request, b []byte) *http.Request {
+	msgReq := r.Clone(ctx)
+	msgReq.Body = io.NopCloser(bytes.NewReader(b))
+	msgReq.ContentLength = int64(len(b))
+	msgReq.Method = http.MethodPost
+	msgReq.Header.Set("Content-Type", "application/json")
+	return msgReq
+}
+
+// errorResponse creates an error response for the message. Notifications do not get
+// a response.
+func (p *Proxy) errorResponse(msg *jsonrpcMessage, jerr *jsonError) []byte {
+	metrics.RequestErrors.WithLabelValues(p.methodLabel(msg.Method), strconv.Itoa(jerr.Code)).Inc()
+	if msg.isNotification() {
+		return nil
 	}
+	return errorResponse(msg.ID, jerr)
+}
 
-	// Disallow other JSON-RPC methods.
-	w.WriteHeader(200)
-	w.Write(methodNotFoundError)
+// methodLabel limits the method label values to the methods in the routing policy.
+func (p *Proxy) methodLabel(method string) string {
+	if _, ok := p.policy.Methods[method]; ok {
+		return method
+	}
+	return "other"
+}
+
+// hasValidParams tells if the params are either omitted or structured as
+// an array or an object.
+func hasValidParams(msg *jsonrpcMessage) bool {
+	params := bytes.TrimSpace(msg.Params)
+	if len(params) == 0 || bytes.Equal(params, jsonNull) {
+		return true
+	}
+	return params[0] == '[' || params[0] == '{'
+}
+
+// isBatch tells if the request body is a JSON array.
+func isBatch(b []byte) bool {
+	b = bytes.TrimLeft(b, " \t\r\n")
+	return len(b) > 0 && b[0] == '['
 }
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	limiterIdleTimeout   = 10 * time.Minute
+	limiterSweepInterval = time.Minute
+)
+
+// RateLimit is a token bucket budget. A zero rate disables the limit.
+type RateLimit struct {
+	RPS   float64
+	Burst int
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimiter limits the requests per client IP or per API key, with separate budgets
+// for wrapped, proxied and authorized methods.
+type RateLimiter struct {
+	budgets        map[Route]RateLimit
+	trustedProxies []*net.IPNet
+
+	mu       sync.Mutex
+	limiters map[string]*limiterEntry
+}
+
+// NewRateLimiter creates a new rate limiter. The budgets are keyed by the wrapped,
+// proxied and authorized routes. The X-Forwarded-For header is honored only for
+// the requests from the trusted proxies, which are given as IPs or CIDRs.
+func NewRateLimiter(budgets map[Route]RateLimit, trustedProxies []string) (*RateLimiter, error) {
+	rl := &RateLimiter{
+		budgets:  budgets,
+		limiters: make(map[string]*limiterEntry),
+	}
+	for _, trustedProxy := range trustedProxies {
+		if !strings.Contains(trustedProxy, "/") {
+			if ip := net.ParseIP(trustedProxy); ip != nil && ip.To4() != nil {
+				trustedProxy += "/32"
+			} else {
+				trustedProxy += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(trustedProxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", trustedProxy, err)
+		}
+		rl.trustedProxies = append(rl.trustedProxies, ipNet)
+	}
+	return rl, nil
+}
+
+// Allow consumes a token from the budget of the client for the route class and returns
+// zero if allowed. Otherwise, it returns how long the client should wait before retrying.
+func (rl *RateLimiter) Allow(r *http.Request, policy *MethodPolicy, key *APIKey) time.Duration {
+	class := policy.Route
+	if policy.requiresAuth() {
+		class = RouteAuthorized
+	}
+	budget, ok := rl.budgets[class]
+	if !ok || budget.RPS <= 0 {
+		return 0
+	}
+
+	client := "ip:" + rl.clientIP(r)
+	if key != nil {
+		// The labels are not unique, unlike the key hashes.
+		client = "key:" + key.KeyHash
+	}
+
+	now := time.Now()
+	rl.mu.Lock()
+	entry, ok := rl.limiters[string(class)+"/"+client]
+	if !ok {
+		entry = &limiterEntry{limiter: rate.NewLimiter(rate.Limit(budget.RPS), max(budget.Burst, 1))}
+		rl.limiters[string(class)+"/"+client] = entry
+	}
+	entry.lastSeen = now
+	rl.mu.Unlock()
+
+	reservation := entry.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return 0
+	}
+	reservation.CancelAt(now)
+	return delay
+}
+
+// clientIP finds the client IP from the remote address or, if the request comes
+// from a trusted proxy, from the rightmost untrusted address in X-Forwarded-For.
+func (rl *RateLimiter) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !rl.isTrusted(ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		forwardedIP := strings.TrimSpace(forwarded[i])
+		if len(forwardedIP) == 0 {
+			continue
+		}
+		ip = forwardedIP
+		if !rl.isTrusted(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+func (rl *RateLimiter) isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range rl.trustedProxies {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run removes the idle limiters periodically, until the context is done.
+func (rl *RateLimiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(limiterSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		rl.mu.Lock()
+		for client, entry := range rl.limiters {
+			if time.Since(entry.lastSeen) > limiterIdleTimeout {
+				delete(rl.limiters, client)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+// retryAfterSeconds rounds up the retry delay to seconds.
+func retryAfterSeconds(delay time.Duration) int {
+	return int(math.Ceil(delay.Seconds()))
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// responseBuffer is an http.ResponseWriter which collects the response in memory
+// so that it can be inspected and reassembled before writing it to the client.
+type responseBuffer struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header), statusCode: http.StatusOK}
+}
+
+// Header implements http.ResponseWriter.
+func (rb *responseBuffer) Header() http.Header {
+	return rb.header
+}
+
+// Write implements http.ResponseWriter.
+func (rb *responseBuffer) Write(b []byte) (int, error) {
+	return rb.body.Write(b)
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (rb *responseBuffer) WriteHeader(statusCode int) {
+	rb.statusCode = statusCode
+}
+
+// Bytes returns the collected response body without the surrounding whitespace.
+func (rb *responseBuffer) Bytes() []byte {
+	return bytes.TrimSpace(rb.body.Bytes())
+}
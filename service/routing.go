@@ -0,0 +1,217 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Route tells how a JSON-RPC method is handled by the proxy.
+type Route string
+
+// Routes
+const (
+	// RouteWrapped methods are handled by the local service.
+	RouteWrapped Route = "wrapped"
+	// RouteProxied methods are proxied to the target.
+	RouteProxied Route = "proxied"
+	// RouteAuthorized methods are proxied to the target only for authorized requests.
+	RouteAuthorized Route = "authorized"
+	// RouteDenied methods are never available.
+	RouteDenied Route = "denied"
+)
+
+// wrappableMethods are the methods which are implemented by the local service.
+var wrappableMethods = map[string]struct{}{
+	"eth_sendRawTransaction":     {},
+	"eth_call":                   {},
+	"eth_estimateGas":            {},
+	"eth_simulateV1":             {},
+	"eth_createAccessList":       {},
+	"debug_traceCall":            {},
+	"forta_getTransactionStatus": {},
+}
+
+var defaultWrappedMethods = []string{
+	"eth_sendRawTransaction",
+	"eth_call",
+	"eth_estimateGas",
+	"eth_simulateV1",
+}
+
+// defaultAuthorizedWrappedMethods are wrapped but potentially heavy or sensitive.
+var defaultAuthorizedWrappedMethods = []string{
+	"debug_traceCall",
+	"forta_getTransactionStatus",
+}
+
+var defaultProxiedMethods = []string{
+	"net_version",
+	"eth_chainId",
+	"eth_getBalance",
+	"eth_getTransactionCount",
+	"eth_getBlockByNumber",
+	"eth_getBlockByHash",
+	"eth_blockNumber",
+	"eth_getCode",
+	"eth_gasPrice",
+	"eth_getTransactionReceipt",
+	"eth_feeHistory",
+	"eth_maxPriorityFeePerGas",
+	// Subscriptions work only over WebSocket.
+	"eth_subscribe",
+	"eth_unsubscribe",
+}
+
+// defaultCacheModes are for the proxied methods which return immutable data or data
+// which changes once per block.
+var defaultCacheModes = map[string]CacheMode{
+	"net_version":              CachePermanent,
+	"eth_chainId":              CachePermanent,
+	"eth_getBlockByHash":       CachePermanent,
+	"eth_blockNumber":          CacheTTL,
+	"eth_gasPrice":             CacheTTL,
+	"eth_feeHistory":           CacheTTL,
+	"eth_maxPriorityFeePerGas": CacheTTL,
+}
+
+// MethodPolicy is the routing policy of a JSON-RPC method.
+type MethodPolicy struct {
+	Route Route `json:"route"`
+	// RequireAuth makes a wrapped or proxied method available only to authorized requests.
+	RequireAuth bool `json:"requireAuth,omitempty"`
+	// MaxRequestBytes limits the size of a single request message.
+	MaxRequestBytes int `json:"maxRequestBytes,omitempty"`
+	// TimeoutSeconds limits the handling duration of a request.
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// Cache enables caching the responses of a proxied method.
+	Cache CacheMode `json:"cache,omitempty"`
+	// CacheTTLMillis overrides the default TTL of the cached responses.
+	CacheTTLMillis int `json:"cacheTTLMillis,omitempty"`
+}
+
+// requiresAuth tells if the method is available only to authorized requests.
+func (mp *MethodPolicy) requiresAuth() bool {
+	return mp.Route == RouteAuthorized || mp.RequireAuth
+}
+
+// timeout returns the handling timeout of the method, if any.
+func (mp *MethodPolicy) timeout() time.Duration {
+	return time.Duration(mp.TimeoutSeconds) * time.Second
+}
+
+// RoutingPolicy is the collection of method routing policies. The methods that are
+// not listed are handled with the default route.
+type RoutingPolicy struct {
+	Default Route                    `json:"default"`
+	Methods map[string]*MethodPolicy `json:"methods"`
+}
+
+// DefaultRoutingPolicy returns the built-in routing policy: a few wrapped methods
+// and the methods which are fundamental to wallets are available to everyone and
+// the rest of the methods are available to authorized requests.
+func DefaultRoutingPolicy() *RoutingPolicy {
+	policy := &RoutingPolicy{
+		Default: RouteAuthorized,
+		Methods: make(map[string]*MethodPolicy),
+	}
+	for _, method := range defaultWrappedMethods {
+		policy.Methods[method] = &MethodPolicy{Route: RouteWrapped}
+	}
+	for _, method := range defaultAuthorizedWrappedMethods {
+		policy.Methods[method] = &MethodPolicy{Route: RouteWrapped, RequireAuth: true}
+	}
+	for _, method := range defaultProxiedMethods {
+		policy.Methods[method] = &MethodPolicy{Route: RouteProxied, Cache: defaultCacheModes[method]}
+	}
+	return policy
+}
+
+// LoadRoutingPolicy reads the routing policy from a JSON file and applies it on top
+// of the default routing policy. The default routing policy is returned if the path
+// is empty.
+func LoadRoutingPolicy(path string) (*RoutingPolicy, error) {
+	policy := DefaultRoutingPolicy()
+	if len(path) == 0 {
+		return policy, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing policy file: %v", err)
+	}
+	var filePolicy RoutingPolicy
+	if err := json.Unmarshal(b, &filePolicy); err != nil {
+		return nil, fmt.Errorf("failed to decode routing policy file: %v", err)
+	}
+	if err := filePolicy.validate(); err != nil {
+		return nil, fmt.Errorf("invalid routing policy: %v", err)
+	}
+
+	if len(filePolicy.Default) > 0 {
+		policy.Default = filePolicy.Default
+	}
+	for method, methodPolicy := range filePolicy.Methods {
+		policy.Methods[method] = methodPolicy
+	}
+	return policy, nil
+}
+
+func (rp *RoutingPolicy) validate() error {
+	switch rp.Default {
+	case "", RouteProxied, RouteAuthorized, RouteDenied:
+	case RouteWrapped:
+		return errors.New("default route cannot be wrapped")
+	default:
+		return fmt.Errorf("unknown default route %q", rp.Default)
+	}
+
+	for method, methodPolicy := range rp.Methods {
+		if len(method) == 0 {
+			return errors.New("empty method name")
+		}
+		if methodPolicy == nil {
+			return fmt.Errorf("%s: empty policy", method)
+		}
+		switch methodPolicy.Route {
+		case RouteProxied, RouteAuthorized, RouteDenied:
+		case RouteWrapped:
+			if _, ok := wrappableMethods[method]; !ok {
+				return fmt.Errorf("%s: method cannot be wrapped", method)
+			}
+		case "":
+			return fmt.Errorf("%s: missing route", method)
+		default:
+			return fmt.Errorf("%s: unknown route %q", method, methodPolicy.Route)
+		}
+		if methodPolicy.MaxRequestBytes < 0 {
+			return fmt.Errorf("%s: negative max request bytes", method)
+		}
+		if methodPolicy.TimeoutSeconds < 0 {
+			return fmt.Errorf("%s: negative timeout", method)
+		}
+		switch methodPolicy.Cache {
+		case CacheNone:
+		case CachePermanent, CacheTTL:
+			if methodPolicy.Route != RouteProxied && methodPolicy.Route != RouteAuthorized {
+				return fmt.Errorf("%s: only proxied methods can be cached", method)
+			}
+		default:
+			return fmt.Errorf("%s: unknown cache mode %q", method, methodPolicy.Cache)
+		}
+		if methodPolicy.CacheTTLMillis < 0 {
+			return fmt.Errorf("%s: negative cache ttl", method)
+		}
+	}
+	return nil
+}
+
+// Lookup finds the policy of a method.
+func (rp *RoutingPolicy) Lookup(method string) *MethodPolicy {
+	if methodPolicy, ok := rp.Methods[method]; ok {
+		return methodPolicy
+	}
+	return &MethodPolicy{Route: rp.Default}
+}
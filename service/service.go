@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
 
@@ -13,27 +15,63 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Attester fallbacks tell what to do with the user transactions while the attester
+// is unavailable.
+const (
+	AttesterFallbackReject  = "reject"
+	AttesterFallbackForward = "forward"
+)
+
 type wrapperService struct {
 	chainID        *big.Int
 	rpcClient      interfaces.RPCClient
 	ethClient      interfaces.EthClient
 	bundler        interfaces.Bundler
 	attester       interfaces.Attester
+	gasBump        *gasBumpPolicy
+	bypass         *BypassOverride
+	verifier       *attestationVerifier
+	fallback       string
+	tracker        *TxTracker
+	queue          *SubmissionQueue
 	enableBundling bool
 }
 
 // NewWrapperService creates a new service that wraps a few JSON-RPC methods.
 func NewWrapperService(
-	chainID *big.Int, rpcClient interfaces.RPCClient, ethClient interfaces.EthClient,
-	bundler interfaces.Bundler, attester interfaces.Attester,
-) *wrapperService {
+	cfg Config, chainID *big.Int, rpcClient interfaces.RPCClient, ethClient interfaces.EthClient,
+	bundler interfaces.Bundler, attester interfaces.Attester, tracker *TxTracker, queue *SubmissionQueue,
+) (*wrapperService, error) {
+	gasBump, err := newGasBumpPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	bypass, err := LoadBypassOverride(cfg.BypassOverridesFile, chainID)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := newAttestationVerifier(cfg, chainID)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.AttesterFallback {
+	case AttesterFallbackReject, AttesterFallbackForward:
+	default:
+		return nil, fmt.Errorf("unknown attester fallback %q", cfg.AttesterFallback)
+	}
 	return &wrapperService{
 		chainID:   chainID,
 		rpcClient: rpcClient,
 		ethClient: ethClient,
 		bundler:   bundler,
 		attester:  attester,
-	}
+		gasBump:   gasBump,
+		bypass:    bypass,
+		verifier:  verifier,
+		fallback:  cfg.AttesterFallback,
+		tracker:   tracker,
+		queue:     queue,
+	}, nil
 }
 
 // Frontrunning:
@@ -48,45 +86,133 @@ func (s *wrapperService) SendRawTransaction(ctx context.Context, userTx hexutil.
 		return common.Hash{}, fmt.Errorf("failed to recover tx signer: %v", err)
 	}
 
+	s.tracker.track(tx.Hash())
+
 	// Refuse to attest to safety of transactions that deploy contracts.
 	if tx.To() == nil {
 		logrus.WithField("txHash", tx.Hash()).Debug("skipping attestation for contract deployment - tx forwarded")
-		return s.sendTx(ctx, userTx)
+		return s.forwardTx(ctx, tx.Hash(), userTx)
 	}
 
 	// The attester should give back a transaction.
-	attestTx, err := s.attester.AttestWithTx(ctx, &interfaces.AttestRequest{
-		From:    signer,
-		To:      *tx.To(),
-		Input:   hexutil.Bytes(tx.Data()).String(),
-		Value:   (*hexutil.Big)(tx.Value()),
-		ChainID: s.chainID.Uint64(),
-	})
+	blockNumber, err := s.ethClient.BlockNumber(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to get block number: %v", err)
+		s.tracker.transition(tx.Hash(), TxStateFailed, err)
+		return common.Hash{}, err
+	}
+	s.tracker.transition(tx.Hash(), TxStateAttesting, nil)
+	attestTx, err := s.attester.AttestWithTx(ctx, newAttestRequest(s.chainID, signer, tx, userTx, blockNumber))
 	if err == interfaces.ErrAttestationNotRequired {
 		logrus.WithField("txHash", tx.Hash()).WithField("tx", tx).Debug("attester says attestation is not required - tx forwarded")
-		return s.sendTx(ctx, userTx)
+		return s.forwardTx(ctx, tx.Hash(), userTx)
+	}
+	if errors.Is(err, interfaces.ErrAttesterUnavailable) && s.fallback == AttesterFallbackForward {
+		logrus.WithField("txHash", tx.Hash()).Warn("attester is unavailable - tx forwarded without attestation")
+		return s.forwardTx(ctx, tx.Hash(), userTx)
 	}
 	if err != nil {
 		logrus.
 			WithError(err).
 			WithField("txHash", tx.Hash()).Debug("attester returned error - operation failed")
-		return common.Hash{}, fmt.Errorf("attestation fails: %v", err)
+		var rejected *interfaces.AttestationRejectedError
+		if errors.As(err, &rejected) {
+			s.tracker.transition(tx.Hash(), TxStateRejected, err)
+			return common.Hash{}, &attestationRejectedError{rejected: rejected}
+		}
+		err = fmt.Errorf("attestation fails: %v", err)
+		s.tracker.transition(tx.Hash(), TxStateFailed, err)
+		return common.Hash{}, err
 	}
 
-	// Send both txs in a bundle.
-	if err := s.bundler.SendBundle(ctx, []hexutil.Bytes{attestTx, userTx}); err != nil {
+	// Do not trust the attester blindly.
+	attestation, err := s.verifier.verify(ctx, s.ethClient, attestTx)
+	if err != nil {
 		logrus.
 			WithError(err).
-			WithField("txHash", tx.Hash()).Debug("failed to send transactions")
-		return common.Hash{}, fmt.Errorf("failed to send transactions: %v", err)
+			WithField("txHash", tx.Hash()).Warn("attester returned invalid attestation - operation failed")
+		err = fmt.Errorf("invalid attestation: %v", err)
+		s.tracker.transition(tx.Hash(), TxStateFailed, err)
+		return common.Hash{}, err
+	}
+	s.tracker.update(tx.Hash(), func(status *TxStatus) {
+		attestationHash := attestation.Hash()
+		status.AttestationTxHash = &attestationHash
+		status.Bundler = s.bundler.Name()
+	})
+
+	// Send both txs in a bundle, in the background if there is a queue.
+	bundle := []hexutil.Bytes{attestTx, userTx}
+	if s.queue != nil {
+		if err := s.queue.enqueue(&submission{userTxHash: tx.Hash(), sender: signer, txs: bundle}); err != nil {
+			s.tracker.transition(tx.Hash(), TxStateFailed, err)
+			return common.Hash{}, err
+		}
+		return tx.Hash(), nil
+	}
+	// The bundle should not be left half sent if the request is done first, so it is
+	// sent to the end in the background and its outcome is still tracked.
+	done := make(chan error, 1)
+	go func() {
+		done <- sendBundle(context.WithoutCancel(ctx), s.bundler, s.tracker, tx.Hash(), bundle)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			logrus.
+				WithError(err).
+				WithField("txHash", tx.Hash()).Debug("failed to send transactions")
+			return common.Hash{}, err
+		}
+	case <-ctx.Done():
+		logrus.WithField("txHash", tx.Hash()).Warn("request is done before the transactions are sent - sending in the background")
 	}
 	return tx.Hash(), nil
 }
 
+// forwardTx sends the user transaction without an attestation.
+func (s *wrapperService) forwardTx(ctx context.Context, userTxHash common.Hash, userTx hexutil.Bytes) (common.Hash, error) {
+	h, err := s.sendTx(ctx, userTx)
+	if err != nil {
+		s.tracker.transition(userTxHash, TxStateFailed, err)
+		return h, err
+	}
+	s.tracker.transition(userTxHash, TxStateForwarded, nil)
+	return h, nil
+}
+
 func (s *wrapperService) sendTx(ctx context.Context, tx hexutil.Bytes) (common.Hash, error) {
 	return s.ethClient.SendRawTransaction(ctx, tx)
 }
 
+// newAttestRequest makes an attest request with all of the user transaction context.
+func newAttestRequest(
+	chainID *big.Int, signer common.Address, tx *types.Transaction, rawTx hexutil.Bytes, blockNumber uint64,
+) *interfaces.AttestRequest {
+	req := &interfaces.AttestRequest{
+		From:        signer,
+		To:          *tx.To(),
+		Input:       hexutil.Bytes(tx.Data()).String(),
+		Value:       (*hexutil.Big)(tx.Value()),
+		ChainID:     chainID.Uint64(),
+		Nonce:       hexutil.Uint64(tx.Nonce()),
+		Gas:         hexutil.Uint64(tx.Gas()),
+		Type:        hexutil.Uint64(tx.Type()),
+		AccessList:  tx.AccessList(),
+		TxHash:      tx.Hash(),
+		RawTx:       rawTx,
+		BlockNumber: hexutil.Uint64(blockNumber),
+	}
+	switch tx.Type() {
+	case types.LegacyTxType, types.AccessListTxType:
+		req.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	default:
+		req.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		req.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+	}
+	return req
+}
+
 func txToArgs(signer common.Address, tx *types.Transaction) (txArgs TransactionArgs) {
 	txArgs.From = &signer
 	txArgs.To = tx.To()
@@ -102,8 +228,13 @@ func txToArgs(signer common.Address, tx *types.Transaction) (txArgs TransactionA
 // State overridden calls:
 
 func (s *wrapperService) Call(ctx context.Context, txArgs TransactionArgs, blockNrOrHash *rpc.BlockNumberOrHash, stateOverride *StateOverride, blockOverrides *BlockOverrides) (result hexutil.Bytes, err error) {
-	stateOverride = AddFortaFirewallStateOverride(stateOverride)
-	err = s.rpcClient.CallContext(ctx, &result, "eth_call", txArgs, blockNrOrHash, stateOverride)
+	blockNrOrHash = latestIfNil(blockNrOrHash)
+	stateOverride = s.bypass.AddFortaFirewallStateOverride(stateOverride)
+	args := []interface{}{txArgs, blockNrOrHash, stateOverride}
+	if blockOverrides != nil {
+		args = append(args, blockOverrides)
+	}
+	err = s.rpcClient.CallContext(ctx, &result, "eth_call", args...)
 	if err != nil {
 		logrus.WithError(err).WithFields(logrus.Fields{
 			"txArgs":         txArgs,
@@ -115,19 +246,67 @@ func (s *wrapperService) Call(ctx context.Context, txArgs TransactionArgs, block
 	return
 }
 
-func (s *wrapperService) EstimateGas(ctx context.Context, txArgs TransactionArgs, blockNrOrHash *rpc.BlockNumberOrHash, stateOverride *StateOverride) (result interface{}, err error) {
-	stateOverride = AddFortaFirewallStateOverride(stateOverride)
-	err = s.rpcClient.CallContext(ctx, &result, "eth_estimateGas", stateOverride)
+func (s *wrapperService) EstimateGas(ctx context.Context, txArgs TransactionArgs, blockNrOrHash *rpc.BlockNumberOrHash, stateOverride *StateOverride) (hexutil.Uint64, error) {
+	blockNrOrHash = latestIfNil(blockNrOrHash)
+	stateOverride = s.bypass.AddFortaFirewallStateOverride(stateOverride)
+	var estimate hexutil.Uint64
+	err := s.rpcClient.CallContext(ctx, &estimate, "eth_estimateGas", txArgs, blockNrOrHash, stateOverride)
 	if err != nil {
 		logrus.WithError(err).WithFields(logrus.Fields{
 			"txArgs":        txArgs,
 			"blockNrOrHash": blockNrOrHash,
 			"stateOverride": stateOverride,
 		}).Info("eth_estimateGas failed")
-		return nil, err
+		return 0, err
+	}
+	gas := s.gasBump.bump(txArgs.To, uint64(estimate))
+
+	// The bumped gas cannot exceed the block gas limit.
+	header, err := s.ethClient.HeaderByNumber(ctx, nil)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to get latest header - not capping the gas estimation")
+		return hexutil.Uint64(gas), nil
+	}
+	if gas > header.GasLimit {
+		gas = max(header.GasLimit, uint64(estimate))
+	}
+	return hexutil.Uint64(gas), nil
+}
+
+func (s *wrapperService) SimulateV1(ctx context.Context, opts SimOpts, blockNrOrHash *rpc.BlockNumberOrHash) (result json.RawMessage, err error) {
+	blockNrOrHash = latestIfNil(blockNrOrHash)
+	for i := range opts.BlockStateCalls {
+		opts.BlockStateCalls[i].StateOverrides = s.bypass.AddFortaFirewallStateOverride(opts.BlockStateCalls[i].StateOverrides)
+	}
+	err = s.rpcClient.CallContext(ctx, &result, "eth_simulateV1", opts, blockNrOrHash)
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"opts":          opts,
+			"blockNrOrHash": blockNrOrHash,
+		}).Info("eth_simulateV1 failed")
+	}
+	return
+}
+
+func (s *wrapperService) CreateAccessList(ctx context.Context, txArgs TransactionArgs, blockNrOrHash *rpc.BlockNumberOrHash, stateOverride *StateOverride) (result json.RawMessage, err error) {
+	blockNrOrHash = latestIfNil(blockNrOrHash)
+	stateOverride = s.bypass.AddFortaFirewallStateOverride(stateOverride)
+	err = s.rpcClient.CallContext(ctx, &result, "eth_createAccessList", txArgs, blockNrOrHash, stateOverride)
+	if err != nil {
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"txArgs":        txArgs,
+			"blockNrOrHash": blockNrOrHash,
+			"stateOverride": stateOverride,
+		}).Info("eth_createAccessList failed")
+	}
+	return
+}
+
+// latestIfNil defaults to the latest block, like the upstream would do.
+func latestIfNil(blockNrOrHash *rpc.BlockNumberOrHash) *rpc.BlockNumberOrHash {
+	if blockNrOrHash != nil {
+		return blockNrOrHash
 	}
-	gas, _ := hexutil.DecodeBig(result.(string))
-	// TODO: Calculate gas bump based on percentage?
-	gas = gas.Add(gas, big.NewInt(50000))
-	return ((*hexutil.Big)(gas)).String(), nil
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+	return &latest
 }
@@ -1,8 +1,15 @@
 package service
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/sirupsen/logrus"
 )
 
 // BypassAddress is used as a flag which is recognized by the SecurityValidator during
@@ -12,15 +19,98 @@ var BypassAddress = common.HexToAddress("0x0000000000000000000000000000000000f01
 // BypassCode is set as the BypassAddress code during state override so that flag is truthy.
 var BypassCode = hexutil.MustDecode("0x10")
 
+// BypassOverride is the state override which makes the SecurityValidator bypass the
+// checkpoint execution on a chain.
+type BypassOverride struct {
+	Address common.Address              `json:"address"`
+	Code    *hexutil.Bytes              `json:"code,omitempty"`
+	Balance *hexutil.Big                `json:"balance,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+// DefaultBypassOverride returns the override with the default bypass address and code.
+func DefaultBypassOverride() *BypassOverride {
+	code := hexutil.Bytes(BypassCode)
+	return &BypassOverride{Address: BypassAddress, Code: &code}
+}
+
+// LoadBypassOverride reads the bypass overrides per chain ID from a JSON file and returns
+// the override of the given chain. The default override is used if the path is empty or
+// the chain is not in the file.
+func LoadBypassOverride(path string, chainID *big.Int) (*BypassOverride, error) {
+	if len(path) == 0 {
+		return DefaultBypassOverride(), nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bypass overrides file: %v", err)
+	}
+	var overrides map[string]*BypassOverride
+	if err := json.Unmarshal(b, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to decode bypass overrides file: %v", err)
+	}
+	bypass, ok := overrides[chainID.String()]
+	if !ok {
+		logrus.WithField("chainId", chainID).Warn("no bypass override configured for chain - using default")
+		return DefaultBypassOverride(), nil
+	}
+	if err := bypass.validate(); err != nil {
+		return nil, fmt.Errorf("invalid bypass override for chain %s: %v", chainID, err)
+	}
+	return bypass, nil
+}
+
+func (bo *BypassOverride) validate() error {
+	if bo.Address == (common.Address{}) {
+		return errors.New("address is missing")
+	}
+	if bo.Code == nil && bo.Balance == nil && len(bo.Storage) == 0 {
+		return errors.New("at least one of code, balance or storage is required")
+	}
+	return nil
+}
+
 // AddFortaFirewallStateOverride adds Forta Firewall state override to make transaction simulation
 // succeed. Without this state override, the transactions which try to execute a checkpoint will look
-// like they revert and cause a confusing experience.
-func AddFortaFirewallStateOverride(stateOverride *StateOverride) *StateOverride {
+// like they revert and cause a confusing experience. The override is merged with the user override
+// of the bypass address: the user values are kept unless the bypass needs to set them.
+func (bo *BypassOverride) AddFortaFirewallStateOverride(stateOverride *StateOverride) *StateOverride {
 	if stateOverride == nil {
 		stateOverride = &StateOverride{}
 	}
-	(*stateOverride)[BypassAddress] = OverrideAccount{
-		Code: (*hexutil.Bytes)(&BypassCode),
+	account := (*stateOverride)[bo.Address]
+	if bo.Code != nil {
+		code := append(hexutil.Bytes{}, *bo.Code...)
+		account.Code = &code
+	}
+	if bo.Balance != nil {
+		balance := (*hexutil.Big)(new(big.Int).Set(bo.Balance.ToInt()))
+		account.Balance = &balance
 	}
+	if len(bo.Storage) > 0 {
+		// State replaces the whole storage so the slots are added to it if the user
+		// has set it. Otherwise, they are added as a diff.
+		if account.State != nil {
+			account.State = mergeStorage(*account.State, bo.Storage)
+		} else {
+			var diff map[common.Hash]common.Hash
+			if account.StateDiff != nil {
+				diff = *account.StateDiff
+			}
+			account.StateDiff = mergeStorage(diff, bo.Storage)
+		}
+	}
+	(*stateOverride)[bo.Address] = account
 	return stateOverride
 }
+
+func mergeStorage(storage, slots map[common.Hash]common.Hash) *map[common.Hash]common.Hash {
+	merged := make(map[common.Hash]common.Hash, len(storage)+len(slots))
+	for slot, value := range storage {
+		merged[slot] = value
+	}
+	for slot, value := range slots {
+		merged[slot] = value
+	}
+	return &merged
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/forta-network/forta-json-rpc-proxy/interfaces"
+	"github.com/forta-network/forta-json-rpc-proxy/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+var errSubmissionQueueFull = errors.New("submission queue is full")
+
+// submission is a bundle of an attestation and a user transaction.
+type submission struct {
+	userTxHash common.Hash
+	sender     common.Address
+	txs        []hexutil.Bytes
+}
+
+// SubmissionQueue sends the bundles in the background so that the requests do not
+// wait for the bundles to be sent. The bundles of a sender are always sent by the
+// same worker, in the order they are enqueued. The bundles are kept in the store, if
+// given, as soon as they are enqueued so that they are not lost on a restart.
+type SubmissionQueue struct {
+	bundler interfaces.Bundler
+	store   interfaces.BundleStore
+	tracker *TxTracker
+	shards  []chan *submission
+}
+
+// NewSubmissionQueue creates a new queue with the given number of workers. Every
+// worker can have up to the queue size number of bundles waiting.
+func NewSubmissionQueue(
+	bundler interfaces.Bundler, store interfaces.BundleStore, tracker *TxTracker, workers, queueSize int,
+) *SubmissionQueue {
+	if workers <= 0 {
+		workers = 1
+	}
+	q := &SubmissionQueue{
+		bundler: bundler,
+		store:   store,
+		tracker: tracker,
+		shards:  make([]chan *submission, workers),
+	}
+	for i := range q.shards {
+		q.shards[i] = make(chan *submission, queueSize)
+	}
+	return q
+}
+
+// Run starts the workers and blocks until the context is done.
+func (q *SubmissionQueue) Run(ctx context.Context) {
+	for _, shard := range q.shards {
+		go q.work(ctx, shard)
+	}
+	<-ctx.Done()
+}
+
+func (q *SubmissionQueue) work(ctx context.Context, shard chan *submission) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sub := <-shard:
+			metrics.SubmissionQueueLength.Dec()
+			// Wait until the bundle is sent to keep the bundles of a sender in order.
+			err := sendBundle(ctx, q.bundler, q.tracker, sub.userTxHash, sub.txs)
+			if err != nil {
+				logrus.WithError(err).WithField("txHash", sub.userTxHash).Warn("failed to send queued transactions")
+			}
+		}
+	}
+}
+
+// enqueue adds the bundle to the queue of the sender's worker.
+func (q *SubmissionQueue) enqueue(sub *submission) error {
+	// The user tx is the last one in the bundle so it identifies the bundle.
+	bundle := &interfaces.PendingBundle{ID: sub.userTxHash, Txs: sub.txs, CreatedAt: time.Now()}
+	if q.store != nil {
+		if err := q.store.Put(bundle); err != nil {
+			return fmt.Errorf("failed to save bundle: %v", err)
+		}
+	}
+	// Transition first as the worker can pick it up right away.
+	q.tracker.transition(sub.userTxHash, TxStateQueued, nil)
+	select {
+	case q.shardOf(sub) <- sub:
+		metrics.SubmissionQueueLength.Inc()
+		return nil
+	default:
+		if q.store != nil {
+			if err := q.store.Delete(bundle); err != nil {
+				logrus.WithError(err).WithField("bundleId", bundle.ID).Error("failed to remove pending bundle")
+			}
+		}
+		return errSubmissionQueueFull
+	}
+}
+
+// resume adds a pending bundle of a previous run to the queue, waiting for room.
+func (q *SubmissionQueue) resume(ctx context.Context, bundle *interfaces.PendingBundle) error {
+	userTx := new(types.Transaction)
+	if err := userTx.UnmarshalBinary(bundle.Txs[len(bundle.Txs)-1]); err != nil {
+		return fmt.Errorf("failed to decode tx: %v", err)
+	}
+	sender, err := types.Sender(types.LatestSignerForChainID(userTx.ChainId()), userTx)
+	if err != nil {
+		return fmt.Errorf("failed to get tx sender: %v", err)
+	}
+	sub := &submission{userTxHash: bundle.ID, sender: sender, txs: bundle.Txs}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case q.shardOf(sub) <- sub:
+		metrics.SubmissionQueueLength.Inc()
+		return nil
+	}
+}
+
+func (q *SubmissionQueue) shardOf(sub *submission) chan *submission {
+	h := fnv.New32a()
+	h.Write(sub.sender.Bytes())
+	return q.shards[h.Sum32()%uint32(len(q.shards))]
+}
+
+// ResumeBundles sends the pending bundles of a previous run again, through the queue
+// if there is one. The bundler resumes every bundle from where it was left off.
+func ResumeBundles(
+	ctx context.Context, bundler interfaces.Bundler, queue *SubmissionQueue, bundles []*interfaces.PendingBundle,
+) {
+	for _, bundle := range bundles {
+		logger := logrus.WithFields(logrus.Fields{"bundleId": bundle.ID, "sent": bundle.Sent})
+		logger.Info("resuming pending bundle")
+		var err error
+		if queue != nil {
+			err = queue.resume(ctx, bundle)
+		} else {
+			err = bundler.SendBundle(ctx, bundle.Txs)
+		}
+		if err != nil {
+			logger.WithError(err).Warn("failed to resume pending bundle")
+		}
+	}
+}
+
+// sendBundle sends the bundle and tracks the outcome.
+func sendBundle(
+	ctx context.Context, bundler interfaces.Bundler, tracker *TxTracker, userTxHash common.Hash, txs []hexutil.Bytes,
+) error {
+	tracker.transition(userTxHash, TxStateBundling, nil)
+	if err := bundler.SendBundle(ctx, txs); err != nil {
+		err = fmt.Errorf("failed to send transactions: %v", err)
+		tracker.transition(userTxHash, TxStateFailed, err)
+		return err
+	}
+	tracker.transition(userTxHash, TxStateSent, nil)
+	return nil
+}
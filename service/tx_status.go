@@ -0,0 +1,227 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/forta-network/forta-json-rpc-proxy/interfaces"
+	"github.com/sirupsen/logrus"
+)
+
+// Transaction states
+const (
+	TxStateReceived  = "received"
+	TxStateAttesting = "attesting"
+	TxStateRejected  = "rejected"
+	TxStateQueued    = "queued"
+	TxStateBundling  = "bundling"
+	TxStateSent      = "sent"
+	TxStateForwarded = "forwarded"
+	TxStateFailed    = "failed"
+)
+
+// Receipt statuses
+const (
+	ReceiptStatusPending  = "pending"
+	ReceiptStatusSuccess  = "success"
+	ReceiptStatusReverted = "reverted"
+)
+
+// TxStateTransition is a state change of a tracked transaction.
+type TxStateTransition struct {
+	State string    `json:"state"`
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+// TxStatus is the status of a transaction submitted to the proxy.
+type TxStatus struct {
+	UserTxHash        common.Hash         `json:"userTxHash"`
+	AttestationTxHash *common.Hash        `json:"attestationTxHash,omitempty"`
+	Bundler           string              `json:"bundler,omitempty"`
+	State             string              `json:"state"`
+	Error             string              `json:"error,omitempty"`
+	History           []TxStateTransition `json:"history"`
+
+	UserTxReceipt      string `json:"userTxReceipt,omitempty"`
+	AttestationReceipt string `json:"attestationReceipt,omitempty"`
+}
+
+func (ts *TxStatus) copy() *TxStatus {
+	cp := *ts
+	cp.History = append([]TxStateTransition{}, ts.History...)
+	return &cp
+}
+
+// TxTracker keeps the statuses of the recent transactions in memory.
+type TxTracker struct {
+	maxEntries int
+	retention  time.Duration
+
+	mu      sync.Mutex
+	ll      *list.List
+	entries map[common.Hash]*list.Element
+}
+
+// NewTxTracker creates a new tracker which keeps up to the max number of transactions
+// for the retention duration.
+func NewTxTracker(maxEntries int, retention time.Duration) *TxTracker {
+	return &TxTracker{
+		maxEntries: maxEntries,
+		retention:  retention,
+		ll:         list.New(),
+		entries:    make(map[common.Hash]*list.Element),
+	}
+}
+
+// track starts tracking a transaction.
+func (tt *TxTracker) track(userTxHash common.Hash) {
+	if tt == nil {
+		return
+	}
+	status := &TxStatus{
+		UserTxHash: userTxHash,
+		State:      TxStateReceived,
+		History:    []TxStateTransition{{State: TxStateReceived, Time: time.Now()}},
+	}
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if el, ok := tt.entries[userTxHash]; ok {
+		tt.ll.Remove(el)
+	}
+	tt.entries[userTxHash] = tt.ll.PushFront(status)
+	tt.evict()
+}
+
+// evict removes the oldest transactions which exceed the max entries or the retention.
+func (tt *TxTracker) evict() {
+	for el := tt.ll.Back(); el != nil; el = tt.ll.Back() {
+		status := el.Value.(*TxStatus)
+		full := tt.maxEntries > 0 && tt.ll.Len() > tt.maxEntries
+		expired := tt.retention > 0 && time.Since(status.History[0].Time) > tt.retention
+		if !full && !expired {
+			return
+		}
+		tt.ll.Remove(el)
+		delete(tt.entries, status.UserTxHash)
+	}
+}
+
+// update runs the update on the status of a tracked transaction.
+func (tt *TxTracker) update(userTxHash common.Hash, update func(status *TxStatus)) {
+	if tt == nil {
+		return
+	}
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if el, ok := tt.entries[userTxHash]; ok {
+		update(el.Value.(*TxStatus))
+	}
+}
+
+// transition moves a tracked transaction to the new state.
+func (tt *TxTracker) transition(userTxHash common.Hash, state string, err error) {
+	tt.update(userTxHash, func(status *TxStatus) {
+		transition := TxStateTransition{State: state, Time: time.Now()}
+		if err != nil {
+			transition.Error = err.Error()
+			status.Error = transition.Error
+		}
+		status.State = state
+		status.History = append(status.History, transition)
+	})
+}
+
+// Get returns a copy of the transaction status, if the transaction is tracked.
+func (tt *TxTracker) Get(userTxHash common.Hash) (*TxStatus, bool) {
+	if tt == nil {
+		return nil, false
+	}
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.evict()
+	el, ok := tt.entries[userTxHash]
+	if !ok {
+		return nil, false
+	}
+	return el.Value.(*TxStatus).copy(), true
+}
+
+// fortaService serves the proxy-specific JSON-RPC methods in the forta namespace.
+type fortaService struct {
+	s *wrapperService
+}
+
+func (fs *fortaService) GetTransactionStatus(ctx context.Context, userTxHash common.Hash) (*TxStatus, error) {
+	status, ok := fs.s.tracker.Get(userTxHash)
+	if !ok {
+		return nil, nil
+	}
+	addReceiptStatuses(ctx, fs.s.ethClient, status)
+	return status, nil
+}
+
+// addReceiptStatuses looks up the receipts of the sent transactions.
+func addReceiptStatuses(ctx context.Context, ethClient interfaces.EthClient, status *TxStatus) {
+	if status.State != TxStateSent && status.State != TxStateForwarded {
+		return
+	}
+	status.UserTxReceipt = receiptStatus(ctx, ethClient, status.UserTxHash)
+	if status.AttestationTxHash != nil {
+		status.AttestationReceipt = receiptStatus(ctx, ethClient, *status.AttestationTxHash)
+	}
+}
+
+func receiptStatus(ctx context.Context, ethClient interfaces.EthClient, txHash common.Hash) string {
+	receipt, err := ethClient.TransactionReceipt(ctx, txHash)
+	switch {
+	case err == ethereum.NotFound:
+		return ReceiptStatusPending
+	case err != nil:
+		logrus.WithError(err).WithField("txHash", txHash).Debug("failed to get receipt for tx status")
+		return ""
+	case receipt.Status == types.ReceiptStatusSuccessful:
+		return ReceiptStatusSuccess
+	default:
+		return ReceiptStatusReverted
+	}
+}
+
+// TxStatusHandler serves the transaction statuses over HTTP.
+type TxStatusHandler struct {
+	tracker   *TxTracker
+	ethClient interfaces.EthClient
+}
+
+// NewTxStatusHandler creates a new handler which serves the statuses from the tracker.
+func NewTxStatusHandler(tracker *TxTracker, ethClient interfaces.EthClient) *TxStatusHandler {
+	return &TxStatusHandler{tracker: tracker, ethClient: ethClient}
+}
+
+// ServeHTTP implements http.Handler. The transaction hash is read from the path.
+func (th *TxStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	hash := r.PathValue("hash")
+	var userTxHash common.Hash
+	if err := userTxHash.UnmarshalText([]byte(hash)); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid transaction hash"})
+		return
+	}
+	status, ok := th.tracker.Get(userTxHash)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "transaction not found"})
+		return
+	}
+	addReceiptStatuses(r.Context(), th.ethClient, status)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,283 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	wsReadLimit    = 32 * 1024 * 1024
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+	wsPongTimeout  = 2 * wsPingInterval
+	// wsMaxInFlight is the maximum number of messages of a connection handled at once.
+	wsMaxInFlight = 16
+)
+
+const (
+	subscribeMethod   = "eth_subscribe"
+	unsubscribeMethod = "eth_unsubscribe"
+)
+
+var errNoUpstreamWebSocket = errors.New("no upstream websocket configured")
+
+// subscriptionTypes are the allowed subscription types and whether they are available
+// only to authorized requests.
+var subscriptionTypes = map[string]bool{
+	"newHeads":               false,
+	"logs":                   false,
+	"newPendingTransactions": true,
+}
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// WebSocketProxy serves JSON-RPC over WebSocket. Every message is handled in the same
+// way as the HTTP proxy does, except the subscriptions which are forwarded to the
+// target WebSocket endpoint.
+type WebSocketProxy struct {
+	proxy  *Proxy
+	target string
+}
+
+// NewWebSocketProxy creates a new WebSocket proxy which forwards the subscriptions to
+// the target WebSocket URL.
+func NewWebSocketProxy(proxy *Proxy, target string) *WebSocketProxy {
+	return &WebSocketProxy{proxy: proxy, target: target}
+}
+
+// ServeHTTP implements http.Handler.
+func (wp *WebSocketProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logrus.WithError(err).Debug("failed to upgrade websocket connection")
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	c := &wsConn{
+		wp:     wp,
+		ctx:    ctx,
+		cancel: cancel,
+		req:    newMessageRequest(ctx, r),
+		conn:   conn,
+		sem:    make(chan struct{}, wsMaxInFlight),
+	}
+	c.serve()
+}
+
+// newMessageRequest creates the base request for handling the messages of a connection,
+// with only the parts of the upgrade request which are relevant to the handling.
+func newMessageRequest(ctx context.Context, upgradeReq *http.Request) *http.Request {
+	r, _ := http.NewRequestWithContext(ctx, http.MethodPost, "/", http.NoBody)
+	r.RemoteAddr = upgradeReq.RemoteAddr
+	for _, header := range []string{"Authorization", "X-Forwarded-For"} {
+		if values := upgradeReq.Header.Values(header); len(values) > 0 {
+			r.Header[header] = values
+		}
+	}
+	return r
+}
+
+type wsConn struct {
+	wp     *WebSocketProxy
+	ctx    context.Context
+	cancel context.CancelFunc
+	req    *http.Request
+	conn   *websocket.Conn
+	sem    chan struct{}
+
+	writeMu sync.Mutex
+
+	upstreamMu sync.Mutex
+	upstream   *websocket.Conn
+}
+
+func (c *wsConn) serve() {
+	defer c.close()
+
+	c.conn.SetReadLimit(wsReadLimit)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	go c.ping()
+	go func() {
+		<-c.ctx.Done()
+		c.conn.Close()
+	}()
+
+	for {
+		_, b, err := c.conn.ReadMessage()
+		if err != nil {
+			logrus.WithError(err).Debug("websocket connection closed")
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		// Stop reading until a message is handled if there are too many in flight.
+		select {
+		case <-c.ctx.Done():
+			return
+		case c.sem <- struct{}{}:
+		}
+		go func() {
+			defer func() { <-c.sem }()
+			c.handle(b)
+		}()
+	}
+}
+
+func (c *wsConn) ping() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		c.writeMu.Lock()
+		err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+		c.writeMu.Unlock()
+		if err != nil {
+			c.cancel()
+			return
+		}
+	}
+}
+
+// close closes the client connection and the upstream connection, if any.
+func (c *wsConn) close() {
+	c.cancel()
+	c.conn.Close()
+	c.upstreamMu.Lock()
+	if c.upstream != nil {
+		c.upstream.Close()
+	}
+	c.upstreamMu.Unlock()
+}
+
+func (c *wsConn) write(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+		logrus.WithError(err).Debug("failed to write websocket message")
+		c.cancel()
+	}
+}
+
+func (c *wsConn) handle(b []byte) {
+	p := c.wp.proxy
+	if isBatch(b) {
+		c.write(p.serveBatch(c.req, b))
+		return
+	}
+
+	adm, errResp := p.admit(c.req, b)
+	if adm == nil {
+		c.write(errResp)
+		return
+	}
+	switch adm.msg.Method {
+	case subscribeMethod:
+		c.write(c.subscribe(adm, b))
+	case unsubscribeMethod:
+		c.write(c.forward(adm, b))
+	default:
+		c.write(p.handle(c.req, b, adm))
+	}
+}
+
+// subscribe checks the subscription type and forwards the subscription to the upstream.
+func (c *wsConn) subscribe(adm *admission, b []byte) []byte {
+	var params []json.RawMessage
+	var subType string
+	if err := json.Unmarshal(adm.msg.Params, &params); err != nil || len(params) == 0 {
+		return c.wp.proxy.errorResponse(&adm.msg, errInvalidParams)
+	}
+	if err := json.Unmarshal(params[0], &subType); err != nil {
+		return c.wp.proxy.errorResponse(&adm.msg, errInvalidParams)
+	}
+	requiresAuth, ok := subscriptionTypes[subType]
+	if !ok {
+		return c.wp.proxy.errorResponse(&adm.msg, errInvalidParams.withData("unsupported subscription type"))
+	}
+	if requiresAuth && !c.wp.proxy.isAuthorized(adm.key, subscribeMethod) {
+		return c.wp.proxy.unauthorizedResponse(&adm.msg, adm.logger)
+	}
+	adm.logger.WithField("subscription", subType).Debug("received subscription request")
+	return c.forward(adm, b)
+}
+
+// forward sends the message to the upstream. The response is relayed to the client
+// by the upstream reader.
+func (c *wsConn) forward(adm *admission, b []byte) []byte {
+	upstream, err := c.getUpstream()
+	if err != nil {
+		adm.logger.WithError(err).Warn("failed to connect to upstream websocket")
+		return c.wp.proxy.errorResponse(&adm.msg, errUpstreamUnavailable)
+	}
+	c.upstreamMu.Lock()
+	defer c.upstreamMu.Unlock()
+	upstream.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := upstream.WriteMessage(websocket.TextMessage, b); err != nil {
+		adm.logger.WithError(err).Warn("failed to write to upstream websocket")
+		return c.wp.proxy.errorResponse(&adm.msg, errUpstreamUnavailable)
+	}
+	return nil
+}
+
+// getUpstream connects to the upstream lazily, at the first subscription.
+func (c *wsConn) getUpstream() (*websocket.Conn, error) {
+	c.upstreamMu.Lock()
+	defer c.upstreamMu.Unlock()
+	if c.upstream != nil {
+		return c.upstream, nil
+	}
+	if len(c.wp.target) == 0 {
+		return nil, errNoUpstreamWebSocket
+	}
+	upstream, _, err := websocket.DefaultDialer.DialContext(c.ctx, c.wp.target, nil)
+	if err != nil {
+		return nil, err
+	}
+	upstream.SetReadLimit(wsReadLimit)
+	c.upstream = upstream
+	go c.relay(upstream)
+	return upstream, nil
+}
+
+// relay writes all upstream messages (responses and subscription notifications) to
+// the client. The client connection is closed with the upstream connection since
+// the subscriptions are gone.
+func (c *wsConn) relay(upstream *websocket.Conn) {
+	defer c.cancel()
+	for {
+		_, b, err := upstream.ReadMessage()
+		if err != nil {
+			logrus.WithError(err).Debug("upstream websocket connection closed")
+			c.writeMu.Lock()
+			c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "upstream closed"),
+				time.Now().Add(wsWriteTimeout))
+			c.writeMu.Unlock()
+			return
+		}
+		c.write(b)
+	}
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/forta-network/forta-json-rpc-proxy/interfaces"
+	bolt "go.etcd.io/bbolt"
+)
+
+var pendingBundlesBucket = []byte("pending_bundles")
+
+// BundleStore keeps the pending bundles in a bbolt database file.
+type BundleStore struct {
+	db *bolt.DB
+}
+
+var _ interfaces.BundleStore = &BundleStore{}
+
+// NewBundleStore opens or creates the database at the given path.
+func NewBundleStore(path string) (*BundleStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle store: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(pendingBundlesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bundle store bucket: %v", err)
+	}
+	return &BundleStore{db: db}, nil
+}
+
+// Get implements interfaces.BundleStore. It returns nil if the bundle is not found.
+func (bs *BundleStore) Get(id common.Hash) (*interfaces.PendingBundle, error) {
+	var bundle *interfaces.PendingBundle
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(pendingBundlesBucket).Get(id.Bytes())
+		if v == nil {
+			return nil
+		}
+		bundle = new(interfaces.PendingBundle)
+		if err := json.Unmarshal(v, bundle); err != nil {
+			return fmt.Errorf("failed to decode bundle %x: %v", id, err)
+		}
+		return nil
+	})
+	return bundle, err
+}
+
+// Put implements interfaces.BundleStore.
+func (bs *BundleStore) Put(bundle *interfaces.PendingBundle) error {
+	b, err := json.Marshal(bundle)
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle: %v", err)
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBundlesBucket).Put(bundle.ID.Bytes(), b)
+	})
+}
+
+// Delete implements interfaces.BundleStore.
+func (bs *BundleStore) Delete(bundle *interfaces.PendingBundle) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBundlesBucket).Delete(bundle.ID.Bytes())
+	})
+}
+
+// Pending implements interfaces.BundleStore.
+func (bs *BundleStore) Pending() ([]*interfaces.PendingBundle, error) {
+	var bundles []*interfaces.PendingBundle
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pendingBundlesBucket).ForEach(func(k, v []byte) error {
+			var bundle interfaces.PendingBundle
+			if err := json.Unmarshal(v, &bundle); err != nil {
+				return fmt.Errorf("failed to decode bundle %x: %v", k, err)
+			}
+			bundles = append(bundles, &bundle)
+			return nil
+		})
+	})
+	return bundles, err
+}
+
+// Close closes the database.
+func (bs *BundleStore) Close() error {
+	return bs.db.Close()
+}
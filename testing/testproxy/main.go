@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/forta-network/forta-json-rpc-proxy/proxy"
 	"github.com/forta-network/forta-json-rpc-proxy/service"
 	"github.com/forta-network/forta-json-rpc-proxy/testing/fake"
@@ -26,5 +27,13 @@ func main() {
 		logrus.WithError(err).Panic("failed to read config")
 	}
 
+	// Trust the fake attester.
+	privateKey, err := crypto.HexToECDSA(cfg.AttesterPrivateKey)
+	if err != nil {
+		logrus.WithError(err).Panic("failed to parse attester private key")
+	}
+	cfg.TrustedAttesterAddresses = []string{crypto.PubkeyToAddress(privateKey.PublicKey).Hex()}
+	cfg.SecurityValidatorAddress = cfg.ValidatorAddress
+
 	proxy.StartWithAttester(cfg.Config, fake.NewAttester(cfg.TargetRPCURL, cfg.ValidatorAddress, cfg.AttesterPrivateKey))
 }